@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const adminUserKey contextKey = "adminUser"
+
+// adminAuth requires HTTP basic auth matching one of the comma separated
+// user:password pairs in Config.AdminUsers, and records the user for audit
+// entries.
+func (app *App) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !validAdmin(app.Config.AdminUsers, user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hungry-girl admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), adminUserKey, user)))
+	}
+}
+
+func validAdmin(admins, user, password string) bool {
+	for _, admin := range strings.Split(admins, ",") {
+		parts := strings.SplitN(strings.TrimSpace(admin), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		if parts[0] == user && subtle.ConstantTimeCompare([]byte(parts[1]), []byte(password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func adminUser(r *http.Request) string {
+	user, _ := r.Context().Value(adminUserKey).(string)
+	return user
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Message: err.Error()})
+}
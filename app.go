@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// App holds everything the handlers need, so tests can build one with fakes
+// instead of a real database, Places API or Messenger.
+type App struct {
+	Config    Config
+	DB        *sql.DB
+	Places    PlacesProvider
+	Messenger MessageSender
+	Users     UserStore
+
+	categories      []PlaceCategory
+	pendingSearches *searchStore
+	lastSearches    *searchStore
+}
+
+// MessageSender delivers messages and sender actions, such as the typing
+// indicator, to Messenger users.
+type MessageSender interface {
+	Send(user string, message FBMessage) error
+	SenderAction(user, action string) error
+}
+
+// NewApp opens the database and sets up the Places and Messenger clients
+// described by c.
+func NewApp(c Config) (*App, error) {
+	db, err := sql.Open("postgres", c.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return newApp(c, db, NewPlacesProvider(c, db), NewMessengerClient(c)), nil
+}
+
+func newApp(c Config, db *sql.DB, places PlacesProvider, messenger MessageSender) *App {
+	return &App{
+		Config:          c,
+		DB:              db,
+		Places:          places,
+		Messenger:       messenger,
+		Users:           NewUserRepository(db),
+		categories:      enabledCategories(c.PlaceTypes),
+		pendingSearches: newSearchStore(),
+		lastSearches:    newSearchStore(),
+	}
+}
+
+// Routes registers the app's handlers on a new mux.
+func (app *App) Routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messenger", app.MessengerRequestHandler)
+	mux.HandleFunc("/directions", app.DirectionsHandler)
+	mux.HandleFunc("/webhooks/typeform", app.TypeformWebhookHandler)
+	mux.HandleFunc("/admin/submissions", app.adminAuth(app.ModerationHandler))
+	mux.HandleFunc("/admin/submissions/", app.adminAuth(app.ModerationHandler))
+	placesAPI := PlacesAPI{Store: NewPlaceRepository(app.DB)}
+	mux.HandleFunc("/admin/api/places", app.adminAuth(placesAPI.ServeHTTP))
+	mux.HandleFunc("/admin/api/places/", app.adminAuth(placesAPI.ServeHTTP))
+	return mux
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sentMessage struct {
+	User    string
+	Message FBMessage
+}
+
+type fakeSender struct {
+	Sent []sentMessage
+	// Log records sender actions and the text of messages in order.
+	Log []string
+	// Err is returned by every send.
+	Err error
+}
+
+func (f *fakeSender) Send(user string, message FBMessage) error {
+	f.Sent = append(f.Sent, sentMessage{User: user, Message: message})
+	f.Log = append(f.Log, message.Text)
+	return f.Err
+}
+
+func (f *fakeSender) SenderAction(user, action string) error {
+	f.Log = append(f.Log, action)
+	return nil
+}
+
+func webhookRequest(user, text string) *http.Request {
+	body := fmt.Sprintf(`{"entry": [{"messaging": [{"sender": {"id": %q}, "message": {"text": %q}}]}]}`, user, text)
+	return httptest.NewRequest("POST", "/messenger", strings.NewReader(body))
+}
+
+func TestMessengerRequestHandlerAsksForLocation(t *testing.T) {
+	tests := []struct {
+		Text     string
+		Expected string
+		Pending  bool
+	}{
+		{
+			Text:     "hello",
+			Expected: "Send your location to get some delicious recommendations!",
+		},
+		{
+			Text:     "somewhere cheap",
+			Expected: "Send me your location and I'll find somewhere cheap and open now.",
+			Pending:  true,
+		},
+		{
+			Text:     "best dumplings in Atlantis",
+			Expected: "I couldn't find Atlantis. Send me your location and I'll look near you.",
+			Pending:  true,
+		},
+	}
+
+	for _, test := range tests {
+		sender := &fakeSender{}
+		app := newApp(Config{}, nil, stubProvider{}, sender)
+		app.Users = fakeUserStore{}
+		w := httptest.NewRecorder()
+		app.Routes().ServeHTTP(w, webhookRequest("1234", test.Text))
+
+		if len(sender.Sent) != 1 {
+			t.Fatalf("expected one message for %q, got %v", test.Text, sender.Sent)
+		}
+		if got := sender.Sent[0]; got.User != "1234" || got.Message.Text != test.Expected {
+			t.Errorf("expected %q for %q, got %q to %s", test.Expected, test.Text, got.Message.Text, got.User)
+		}
+		if _, ok := app.pendingSearches.take("1234"); ok != test.Pending {
+			t.Errorf("expected pending search %v for %q", test.Pending, test.Text)
+		}
+	}
+}
+
+func TestMessengerRequestHandlerShowsTyping(t *testing.T) {
+	sender := &fakeSender{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = fakeUserStore{}
+	app.Routes().ServeHTTP(httptest.NewRecorder(), webhookRequest("1234", "best dumplings in Atlantis"))
+
+	expected := []string{
+		senderActionMarkSeen,
+		senderActionTypingOn,
+		"I couldn't find Atlantis. Send me your location and I'll look near you.",
+		senderActionTypingOff,
+	}
+	if !reflect.DeepEqual(sender.Log, expected) {
+		t.Errorf("expected %q, got %q", expected, sender.Log)
+	}
+}
+
+func TestMessengerRequestHandlerPostback(t *testing.T) {
+	sender := &fakeSender{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = fakeUserStore{}
+	body := `{"entry": [{"messaging": [{"sender": {"id": "1234"}, "postback": {"title": "Get Started", "payload": "GET_STARTED"}}]}]}`
+	app.Routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/messenger", strings.NewReader(body)))
+
+	if len(sender.Sent) == 0 || sender.Sent[0].Message.Text != welcomeMessages[0] {
+		t.Errorf("expected the welcome message, got %v", sender.Sent)
+	}
+}
+
+func TestMessengerRequestHandlerDietaryQuickReply(t *testing.T) {
+	sender := &fakeSender{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = fakeUserStore{}
+	body := `{"entry": [{"messaging": [{"sender": {"id": "1234"}, "message": {"text": "No thanks", "quick_reply": {"payload": "DIET:done"}}}]}]}`
+	app.Routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/messenger", strings.NewReader(body)))
+
+	if len(sender.Sent) != 1 || len(sender.Sent[0].Message.QuickReplies) != 1 || sender.Sent[0].Message.QuickReplies[0].ContentType != "location" {
+		t.Errorf("expected a location prompt, got %v", sender.Sent)
+	}
+}
+
+func TestMessengerRequestHandlerVerifiesToken(t *testing.T) {
+	app := newApp(Config{FBVerificationToken: "s3cret"}, nil, stubProvider{}, &fakeSender{})
+
+	w := httptest.NewRecorder()
+	app.Routes().ServeHTTP(w, httptest.NewRequest("GET", "/messenger?hub.verify_token=s3cret&hub.challenge=42", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "42" {
+		t.Errorf("expected challenge echoed, got %d %q", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	app.Routes().ServeHTTP(w, httptest.NewRequest("GET", "/messenger?hub.verify_token=wrong&hub.challenge=42", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for wrong token, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestMessengerRequestHandlerTracksBlockedUsers(t *testing.T) {
+	sender := &fakeSender{Err: UserBlockedError{User: "1234"}}
+	users := fakeUserStore{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = users
+
+	app.sendText("1234", "hello")
+	if !users["1234"].Blocked {
+		t.Fatalf("expected the user to be marked blocked")
+	}
+
+	sender.Err = nil
+	app.Routes().ServeHTTP(httptest.NewRecorder(), webhookRequest("1234", "hello"))
+	if users["1234"].Blocked {
+		t.Errorf("expected a message from the user to unblock them")
+	}
+}
+
+func TestMessengerRequestHandlerRecordsNewUsers(t *testing.T) {
+	users := fakeUserStore{}
+	app := newApp(Config{}, nil, stubProvider{}, &fakeSender{})
+	app.Users = users
+
+	app.Routes().ServeHTTP(httptest.NewRecorder(), webhookRequest("1234", "hello"))
+
+	if user, ok := users["1234"]; !ok || user.FirstSeen.IsZero() || user.Onboarded {
+		t.Errorf("expected a new user to be recorded, got %+v", user)
+	}
+}
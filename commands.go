@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runCommand runs the subcommand named by args[0] instead of starting the
+// server.
+func (app *App) runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return app.migrateCommand(args[1:])
+	case "moderate":
+		return app.moderateCommand(args[1:])
+	case "import":
+		return app.importCommand(args[1:])
+	case "export":
+		return app.exportCommand(args[1:])
+	case "osm":
+		return app.osmCommand(args[1:])
+	case "setup":
+		return app.setupCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// migrateCommand applies pending migrations, or with "down" reverts the last
+// -steps of them.
+func (app *App) migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert with down")
+	direction := "up"
+	if len(args) > 0 && (args[0] == "up" || args[0] == "down") {
+		direction = args[0]
+		args = args[1:]
+	}
+	flags.Parse(args)
+
+	if direction == "down" {
+		if *steps < 1 {
+			return errors.New("steps must be at least 1")
+		}
+		return Rollback(app.DB, *steps)
+	}
+	return Migrate(app.DB)
+}
+
+// moderateCommand works through submitted recommendations:
+//
+//	moderate list [-status pending]
+//	moderate approve|reject <googleid> [-by name]
+//	moderate edit <googleid> [-name name] [-score 4.5] [-by name]
+//	moderate audit <googleid>
+func (app *App) moderateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: moderate list|approve|reject|edit|audit")
+	}
+	action, args := args[0], args[1:]
+
+	flags := flag.NewFlagSet("moderate "+action, flag.ExitOnError)
+	status := flags.String("status", StatusPending, "status of places to list")
+	by := flags.String("by", os.Getenv("USER"), "moderator recorded in the audit trail")
+	name := flags.String("name", "", "new name for edit")
+	score := flags.Float64("score", -1, "new curator score (0-5) for edit")
+
+	var placeID string
+	if action != "list" {
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			return fmt.Errorf("usage: moderate %s <googleid>", action)
+		}
+		placeID, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+
+	switch action {
+	case "list":
+		places, err := NewPlaceRepository(app.DB).ListByStatus(*status)
+		if err != nil {
+			return err
+		}
+		for _, p := range places {
+			fmt.Printf("%s\t%s\t%v,%v\t%v\n", p.ID, p.Name, p.Location.Latitude, p.Location.Longitude, formatCuratorScore(p.CuratorScore))
+		}
+		return nil
+	case "approve":
+		return NewPlaceRepository(app.DB).SetStatus(placeID, StatusApproved, *by)
+	case "reject":
+		return NewPlaceRepository(app.DB).SetStatus(placeID, StatusRejected, *by)
+	case "edit":
+		var edit PlaceEdit
+		if *name != "" {
+			edit.Name = name
+		}
+		if *score >= 0 {
+			edit.CuratorScore = score
+		}
+		return NewPlaceRepository(app.DB).Edit(placeID, edit, *by)
+	case "audit":
+		entries, err := NewPlaceRepository(app.DB).Audit(placeID)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			changes, _ := json.Marshal(e.Changes)
+			fmt.Printf("%s\t%s\t%s\t%s\n", e.CreatedAt.Format("2006-01-02 15:04"), e.Actor, e.Action, changes)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown moderate action %q", action)
+	}
+}
+
+// importCommand upserts places from a CSV or GeoJSON file:
+//
+//	import [-format csv|geojson] [-enrich] [-by name] <file>
+func (app *App) importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "csv or geojson, guessed from the file extension if unset")
+	enrich := flags.Bool("enrich", false, "fill in missing names and coordinates from Google Places")
+	by := flags.String("by", os.Getenv("USER"), "curator recorded in the audit trail")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: import [-format csv|geojson] [-enrich] [-by name] <file>")
+	}
+	filename := flags.Arg(0)
+	if *format == "" {
+		*format = formatFromFilename(filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	places, err := ReadPlaces(f, *format)
+	if err != nil {
+		return err
+	}
+	err = preparePlaces(places, app.Places, *enrich)
+	if err != nil {
+		return err
+	}
+
+	repo := NewPlaceRepository(app.DB)
+	for _, p := range places {
+		if err := repo.Upsert(p, *by); err != nil {
+			return fmt.Errorf("%s: %s", p.ID, err)
+		}
+	}
+	fmt.Printf("imported %d places\n", len(places))
+	return nil
+}
+
+// exportCommand writes curated places as CSV or GeoJSON:
+//
+//	export [-format csv|geojson] [-status approved] [file]
+func (app *App) exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "", "csv or geojson, guessed from the file extension if unset")
+	status := flags.String("status", "", "only export places with this status")
+	flags.Parse(args)
+
+	out := os.Stdout
+	if flags.NArg() > 0 {
+		f, err := os.Create(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+		if *format == "" {
+			*format = formatFromFilename(flags.Arg(0))
+		}
+	}
+	if *format == "" {
+		*format = formatCSV
+	}
+
+	repo := NewPlaceRepository(app.DB)
+	var places []Place
+	for offset := 0; ; offset += maxPerPage {
+		page, total, err := repo.List(PlaceQuery{Status: *status, Limit: maxPerPage, Offset: offset})
+		if err != nil {
+			return err
+		}
+		places = append(places, page...)
+		if offset+maxPerPage >= total {
+			break
+		}
+	}
+	return WritePlaces(out, *format, places)
+}
+
+// osmCommand loads eating and drinking places from an OpenStreetMap extract
+// into osm_places, replacing any loaded before:
+//
+//	osm import [-format pbf|geojson|overpass] <file>
+func (app *App) osmCommand(args []string) error {
+	usage := errors.New("usage: osm import [-format pbf|geojson|overpass] <file>")
+	if len(args) == 0 || args[0] != "import" {
+		return usage
+	}
+	flags := flag.NewFlagSet("osm import", flag.ExitOnError)
+	format := flags.String("format", "", "pbf, geojson or overpass, guessed from the file extension if unset")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		return usage
+	}
+	filename := flags.Arg(0)
+	if *format == "" {
+		*format = osmFormatFromFilename(filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	places, err := ReadOSMPlaces(f, *format)
+	if err != nil {
+		return err
+	}
+	setOSMTimezone(places, app.Config.OSMTimezone)
+	if err := SaveOSMPlaces(app.DB, places); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d places from OpenStreetMap\n", len(places))
+	return nil
+}
+
+// setupCommand brings the page's Messenger profile up to date:
+//
+//	setup [-dry-run]
+func (app *App) setupCommand(args []string) error {
+	flags := flag.NewFlagSet("setup", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "show what would change without changing it")
+	flags.Parse(args)
+	if app.Config.FBPageToken == "" {
+		return errors.New("FB_PAGE_TOKEN is not set")
+	}
+
+	manager := ProfileManager{Client: NewMessengerClient(app.Config)}
+	changes, err := manager.Sync(messengerProfile(app.categories), *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun && !changes.Empty() {
+		fmt.Printf("messenger profile would change: %s\n", changes)
+		return nil
+	}
+	fmt.Printf("messenger profile: %s\n", changes)
+	return nil
+}
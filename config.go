@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	closedCuratedHide = "hide"
+	closedCuratedFlag = "flag"
+)
+
+// Config holds the settings the app reads once at startup.
+type Config struct {
+	// APIBaseURL overrides the Google Places API's base URL, for tests.
+	APIBaseURL string
+
+	DatabaseURL string
+	Port        string
+	AppURL      string
+	// LinkSecret signs the directions links sent to users.
+	LinkSecret string
+
+	FBPageToken         string
+	FBVerificationToken string
+	// MessengerRateLimit is the most messages a second sent to Messenger.
+	MessengerRateLimit float64
+
+	GooglePlacesAPIKey string
+	FoursquareAPIKey   string
+	YelpAPIKey         string
+	PlacesAPI          string
+	PlacesProviders    []string
+	PlaceTypes         []string
+	OSMExtract         string
+	OSMTimezone        string
+	// PlacesTimezone is the time zone of Yelp's opening hours, and of
+	// Foursquare's when it doesn't say.
+	PlacesTimezone string
+	// SearchArea is where areas named by users are looked for when we don't
+	// know where they are, such as "London".
+	SearchArea string
+
+	AdminUsers            string
+	TypeformSecret        string
+	TypeformRestaurantRef string
+	TypeformAreaRef       string
+
+	FeedbackDelay time.Duration
+	// ClosedCuratedPlaces is "hide" to leave out curated places that are
+	// closed, or "flag" to show them marked as closed.
+	ClosedCuratedPlaces string
+	Ranking             RankingWeights
+}
+
+// setting is a config value, read from the environment variable Name, the
+// YAML key of the same name in lower case or the flag with dashes for
+// underscores, e.g. DATABASE_URL, database_url or -database-url.
+type setting struct {
+	Name    string
+	Default string
+	Usage   string
+	// Field points to where the value goes in a Config: a string, a list, a
+	// duration or a number.
+	Field func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{Name: "DATABASE_URL", Usage: "Postgres connection string",
+		Field: func(c *Config) interface{} { return &c.DatabaseURL }},
+	{Name: "PORT", Default: "8080", Usage: "port to listen on",
+		Field: func(c *Config) interface{} { return &c.Port }},
+	{Name: "APP_URL", Usage: "public URL of the app, for directions links",
+		Field: func(c *Config) interface{} { return &c.AppURL }},
+	{Name: "LINK_SECRET", Usage: "secret directions links are signed with",
+		Field: func(c *Config) interface{} { return &c.LinkSecret }},
+	{Name: "FB_PAGE_TOKEN", Usage: "Facebook page access token",
+		Field: func(c *Config) interface{} { return &c.FBPageToken }},
+	{Name: "FB_VERIFICATION_TOKEN", Usage: "token Messenger sends to verify the webhook",
+		Field: func(c *Config) interface{} { return &c.FBVerificationToken }},
+	{Name: "MESSENGER_RATE_LIMIT", Default: "20", Usage: "most messages a second to send to Messenger",
+		Field: func(c *Config) interface{} { return &c.MessengerRateLimit }},
+	{Name: "GOOGLE_PLACES_API_KEY", Usage: "Google Places API key",
+		Field: func(c *Config) interface{} { return &c.GooglePlacesAPIKey }},
+	{Name: "FOURSQUARE_API_KEY", Usage: "Foursquare Places API key",
+		Field: func(c *Config) interface{} { return &c.FoursquareAPIKey }},
+	{Name: "YELP_API_KEY", Usage: "Yelp Fusion API key",
+		Field: func(c *Config) interface{} { return &c.YelpAPIKey }},
+	{Name: "PLACES_API", Default: placesAPILegacy, Usage: "Google Places API to use: legacy or new",
+		Field: func(c *Config) interface{} { return &c.PlacesAPI }},
+	{Name: "PLACES_PROVIDERS", Default: providerGoogle, Usage: "places providers to try in order: google, foursquare, yelp, osm",
+		Field: func(c *Config) interface{} { return &c.PlacesProviders }},
+	{Name: "PLACE_TYPES", Usage: "place types users can search for, all of them if unset",
+		Field: func(c *Config) interface{} { return &c.PlaceTypes }},
+	{Name: "OSM_EXTRACT", Usage: "Overpass JSON extract to serve instead of the osm_places table",
+		Field: func(c *Config) interface{} { return &c.OSMExtract }},
+	{Name: "OSM_TIMEZONE", Default: time.Local.String(), Usage: "time zone of OpenStreetMap opening hours",
+		Field: func(c *Config) interface{} { return &c.OSMTimezone }},
+	{Name: "PLACES_TIMEZONE", Default: time.Local.String(), Usage: "time zone of Foursquare and Yelp opening hours",
+		Field: func(c *Config) interface{} { return &c.PlacesTimezone }},
+	{Name: "SEARCH_AREA", Usage: "city or country to look for named areas in when the user's location is unknown",
+		Field: func(c *Config) interface{} { return &c.SearchArea }},
+	{Name: "ADMIN_USERS", Usage: "comma separated user:password pairs for the admin API",
+		Field: func(c *Config) interface{} { return &c.AdminUsers }},
+	{Name: "TYPEFORM_SECRET", Usage: "secret Typeform signs webhooks with",
+		Field: func(c *Config) interface{} { return &c.TypeformSecret }},
+	{Name: "TYPEFORM_RESTAURANT_REF", Default: defaultTypeformRestaurantRef, Usage: "ref of the restaurant name question",
+		Field: func(c *Config) interface{} { return &c.TypeformRestaurantRef }},
+	{Name: "TYPEFORM_AREA_REF", Default: defaultTypeformAreaRef, Usage: "ref of the area question",
+		Field: func(c *Config) interface{} { return &c.TypeformAreaRef }},
+	{Name: "FEEDBACK_DELAY", Default: defaultFeedbackDelay.String(), Usage: "how long after directions to ask for feedback",
+		Field: func(c *Config) interface{} { return &c.FeedbackDelay }},
+	{Name: "CLOSED_CURATED_PLACES", Default: closedCuratedHide, Usage: "hide or flag curated places that are closed",
+		Field: func(c *Config) interface{} { return &c.ClosedCuratedPlaces }},
+	{Name: "RANK_WEIGHT_DISTANCE", Default: fmt.Sprint(DefaultRankingWeights().Distance), Usage: "ranking weight of distance",
+		Field: func(c *Config) interface{} { return &c.Ranking.Distance }},
+	{Name: "RANK_WEIGHT_CURATOR", Default: fmt.Sprint(DefaultRankingWeights().CuratorScore), Usage: "ranking weight of the curator's score",
+		Field: func(c *Config) interface{} { return &c.Ranking.CuratorScore }},
+	{Name: "RANK_WEIGHT_FEEDBACK", Default: fmt.Sprint(DefaultRankingWeights().Feedback), Usage: "ranking weight of user feedback",
+		Field: func(c *Config) interface{} { return &c.Ranking.Feedback }},
+	{Name: "RANK_WEIGHT_FRESHNESS", Default: fmt.Sprint(DefaultRankingWeights().Freshness), Usage: "ranking weight of recently added places",
+		Field: func(c *Config) interface{} { return &c.Ranking.Freshness }},
+}
+
+// ConfigError lists everything wrong with a config.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// LoadConfig reads the config from, in increasing priority, the defaults, the
+// YAML file named by -config or CONFIG_FILE, the environment and flags. It
+// returns the arguments left after the flags.
+func LoadConfig(args []string) (Config, []string, error) {
+	flags := flag.NewFlagSet("hungry-girl", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file")
+	values := make(map[string]*string)
+	for _, s := range settings {
+		values[s.Name] = flags.String(s.flagName(), "", s.Usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+	flagged := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		flagged[f.Name] = true
+	})
+
+	file := make(map[string]string)
+	if *configFile != "" {
+		var err error
+		file, err = readYAMLConfig(*configFile)
+		if err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	var c Config
+	var problems ConfigError
+	for _, s := range settings {
+		v := s.Default
+		if fv, ok := file[s.Name]; ok {
+			v = fv
+		}
+		if ev := os.Getenv(s.Name); ev != "" {
+			v = ev
+		}
+		if flagged[s.flagName()] {
+			v = *values[s.Name]
+		}
+		if err := s.set(&c, v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", s.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return c, flags.Args(), problems
+	}
+	return c, flags.Args(), nil
+}
+
+func (s setting) flagName() string {
+	return strings.Replace(strings.ToLower(s.Name), "_", "-", -1)
+}
+
+func (s setting) set(c *Config, v string) error {
+	v = strings.TrimSpace(v)
+	switch field := s.Field(c).(type) {
+	case *string:
+		*field = v
+	case *[]string:
+		*field = splitList(v)
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 1h30m", v)
+		}
+		*field = d
+	case *float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*field = f
+	}
+	return nil
+}
+
+// Validate reports missing or inconsistent settings. Serving the bot needs
+// more of them than the subcommands do.
+func (c Config) Validate(serving bool) error {
+	var problems ConfigError
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.DatabaseURL == "" {
+		problem("DATABASE_URL is not set")
+	}
+	if _, err := time.LoadLocation(c.OSMTimezone); err != nil {
+		problem("OSM_TIMEZONE: unknown time zone %q", c.OSMTimezone)
+	}
+	if _, err := time.LoadLocation(c.PlacesTimezone); err != nil {
+		problem("PLACES_TIMEZONE: unknown time zone %q", c.PlacesTimezone)
+	}
+	for _, t := range c.PlaceTypes {
+		if _, ok := findCategory(placeCategories, t); !ok {
+			problem("PLACE_TYPES: unknown place type %q", t)
+		}
+	}
+	if c.ClosedCuratedPlaces != closedCuratedHide && c.ClosedCuratedPlaces != closedCuratedFlag {
+		problem("CLOSED_CURATED_PLACES must be %s or %s, not %q", closedCuratedHide, closedCuratedFlag, c.ClosedCuratedPlaces)
+	}
+	if c.MessengerRateLimit <= 0 {
+		problem("MESSENGER_RATE_LIMIT must be positive")
+	}
+	if c.FeedbackDelay <= 0 {
+		problem("FEEDBACK_DELAY must be positive")
+	}
+	if c.PlacesAPI != placesAPILegacy && c.PlacesAPI != placesAPINew {
+		problem("PLACES_API must be %s or %s, not %q", placesAPILegacy, placesAPINew, c.PlacesAPI)
+	}
+
+	if len(c.PlacesProviders) == 0 {
+		problem("PLACES_PROVIDERS is empty")
+	}
+	usesOSM := false
+	for _, name := range c.PlacesProviders {
+		switch name {
+		case providerGoogle:
+			if serving && c.GooglePlacesAPIKey == "" {
+				problem("GOOGLE_PLACES_API_KEY is not set, but google is in PLACES_PROVIDERS")
+			}
+		case providerFoursquare:
+			if serving && c.FoursquareAPIKey == "" {
+				problem("FOURSQUARE_API_KEY is not set, but foursquare is in PLACES_PROVIDERS")
+			}
+		case providerYelp:
+			if serving && c.YelpAPIKey == "" {
+				problem("YELP_API_KEY is not set, but yelp is in PLACES_PROVIDERS")
+			}
+		case providerOSM:
+			usesOSM = true
+		default:
+			problem("PLACES_PROVIDERS: unknown places provider %q", name)
+		}
+	}
+	if c.OSMExtract != "" {
+		if !usesOSM {
+			problem("OSM_EXTRACT is set, but osm is not in PLACES_PROVIDERS")
+		} else if _, err := os.Stat(c.OSMExtract); err != nil {
+			problem("OSM_EXTRACT: %s", err)
+		}
+	}
+	for _, admin := range splitList(c.AdminUsers) {
+		if parts := strings.SplitN(admin, ":", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			problem("ADMIN_USERS: %q is not user:password", strings.SplitN(admin, ":", 2)[0])
+		}
+	}
+
+	if serving {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			problem("PORT must be a port number, not %q", c.Port)
+		}
+		if u, err := url.Parse(c.AppURL); c.AppURL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("APP_URL must be an http or https URL, not %q", c.AppURL)
+		}
+		if c.LinkSecret == "" {
+			problem("LINK_SECRET is not set")
+		}
+		if c.FBPageToken == "" {
+			problem("FB_PAGE_TOKEN is not set")
+		}
+		if c.FBVerificationToken == "" {
+			problem("FB_VERIFICATION_TOKEN is not set")
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// readYAMLConfig reads a YAML file of top level "key: value" settings, whose
+// keys are the settings' names in lower case. Lists may be written as
+// [a, b] or a, b.
+func readYAMLConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	known := make(map[string]bool)
+	for _, s := range settings {
+		known[s.Name] = true
+	}
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || line[0] == '-' {
+			return nil, fmt.Errorf("%s:%d: only top level key: value settings are supported", path, n)
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key: value", path, n)
+		}
+		key := strings.ToUpper(strings.TrimSpace(parts[0]))
+		if !known[key] {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", path, n, strings.TrimSpace(parts[0]))
+		}
+		value, err := yamlScalar(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// yamlScalar reads a plain, quoted or [flow, list] value, dropping any
+// trailing comment.
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"', '\'':
+		end := strings.IndexByte(s[1:], s[0])
+		if end == -1 {
+			return "", errors.New("unterminated string")
+		}
+		if rest := strings.TrimSpace(s[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", errors.New("unexpected text after string")
+		}
+		return s[1 : end+1], nil
+	case '[':
+		end := strings.IndexByte(s, ']')
+		if end == -1 {
+			return "", errors.New("unterminated list")
+		}
+		var items []string
+		for _, item := range splitList(s[1:end]) {
+			items = append(items, strings.Trim(item, `"'`))
+		}
+		return strings.Join(items, ","), nil
+	}
+	if i := strings.Index(s, " #"); i != -1 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() Config {
+	return Config{
+		DatabaseURL:         "postgres://localhost/hungrygirl",
+		Port:                "8080",
+		AppURL:              "https://hungry-girl.example.com",
+		LinkSecret:          "secret",
+		FBPageToken:         "token",
+		FBVerificationToken: "verify",
+		MessengerRateLimit:  20,
+		GooglePlacesAPIKey:  "key",
+		PlacesAPI:           placesAPILegacy,
+		PlacesProviders:     []string{providerGoogle},
+		OSMTimezone:         "UTC",
+		FeedbackDelay:       time.Hour,
+		ClosedCuratedPlaces: closedCuratedHide,
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	yaml := `# hungry-girl
+database_url: "postgres://yaml/hungrygirl"
+port: 5000
+places_providers: [osm, google]
+feedback_delay: 30m # half an hour
+`
+	if err := ioutil.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PORT", "6000")
+	os.Setenv("FEEDBACK_DELAY", "45m")
+	defer os.Unsetenv("PORT")
+	defer os.Unsetenv("FEEDBACK_DELAY")
+
+	c, args, err := LoadConfig([]string{"-config", path, "-feedback-delay", "2h", "migrate", "down"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(args, []string{"migrate", "down"}) {
+		t.Errorf("expected the subcommand to be left, got %v", args)
+	}
+	if c.DatabaseURL != "postgres://yaml/hungrygirl" {
+		t.Errorf("expected the database from the file, got %q", c.DatabaseURL)
+	}
+	if c.Port != "6000" {
+		t.Errorf("expected the environment to override the file, got port %q", c.Port)
+	}
+	if c.FeedbackDelay != 2*time.Hour {
+		t.Errorf("expected the flag to override the environment, got %s", c.FeedbackDelay)
+	}
+	if !reflect.DeepEqual(c.PlacesProviders, []string{"osm", "google"}) {
+		t.Errorf("expected providers from the file, got %v", c.PlacesProviders)
+	}
+	if c.PlacesAPI != placesAPILegacy || c.Ranking != DefaultRankingWeights() {
+		t.Errorf("expected defaults, got %q and %+v", c.PlacesAPI, c.Ranking)
+	}
+}
+
+func TestLoadConfigReportsBadValues(t *testing.T) {
+	os.Setenv("RANK_WEIGHT_DISTANCE", "2.5")
+	os.Setenv("RANK_WEIGHT_FEEDBACK", "not a number")
+	defer os.Unsetenv("RANK_WEIGHT_DISTANCE")
+	defer os.Unsetenv("RANK_WEIGHT_FEEDBACK")
+
+	c, _, err := LoadConfig([]string{"-feedback-delay", "soon"})
+	problems, ok := err.(ConfigError)
+	if !ok || len(problems) != 2 {
+		t.Fatalf("expected two problems, got %v", err)
+	}
+	if !strings.HasPrefix(problems[0], "FEEDBACK_DELAY") || !strings.HasPrefix(problems[1], "RANK_WEIGHT_FEEDBACK") {
+		t.Errorf("expected the bad settings to be named, got %v", problems)
+	}
+	if c.Ranking.Distance != 2.5 {
+		t.Errorf("expected the good weight to be read, got %v", c.Ranking.Distance)
+	}
+}
+
+func TestReadYAMLConfigErrors(t *testing.T) {
+	tests := []struct {
+		YAML     string
+		Expected string
+	}{
+		{YAML: "databse_url: x", Expected: `unknown setting "databse_url"`},
+		{YAML: "places_providers:\n  - google", Expected: "only top level"},
+		{YAML: `app_url: "https://example.com`, Expected: "unterminated string"},
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(path, []byte(test.YAML), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readYAMLConfig(path); err == nil || !strings.Contains(err.Error(), test.Expected) {
+			t.Errorf("expected %q error for %q, got %v", test.Expected, test.YAML, err)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Change   func(c *Config)
+		Serving  bool
+		Expected []string
+	}{
+		{Name: "valid", Change: func(c *Config) {}, Serving: true},
+		{
+			Name:     "missing port",
+			Change:   func(c *Config) { c.Port = "" },
+			Serving:  true,
+			Expected: []string{`PORT must be a port number, not ""`},
+		},
+		{
+			Name: "missing keys",
+			Change: func(c *Config) {
+				c.GooglePlacesAPIKey = ""
+				c.PlacesProviders = []string{providerGoogle, providerYelp}
+				c.FBPageToken = ""
+				c.LinkSecret = ""
+			},
+			Serving: true,
+			Expected: []string{
+				"GOOGLE_PLACES_API_KEY is not set, but google is in PLACES_PROVIDERS",
+				"YELP_API_KEY is not set, but yelp is in PLACES_PROVIDERS",
+				"LINK_SECRET is not set",
+				"FB_PAGE_TOKEN is not set",
+			},
+		},
+		{
+			Name: "commands only need the database",
+			Change: func(c *Config) {
+				c.Port, c.AppURL, c.LinkSecret, c.FBPageToken, c.GooglePlacesAPIKey = "", "", "", "", ""
+			},
+		},
+		{
+			Name: "inconsistent",
+			Change: func(c *Config) {
+				c.DatabaseURL = ""
+				c.OSMExtract = "barcelona.json"
+				c.PlaceTypes = []string{"cafe", "nightclub"}
+				c.AdminUsers = "nicola:s3cret, sam"
+				c.ClosedCuratedPlaces = "show"
+			},
+			Expected: []string{
+				"DATABASE_URL is not set",
+				`PLACE_TYPES: unknown place type "nightclub"`,
+				`CLOSED_CURATED_PLACES must be hide or flag, not "show"`,
+				"OSM_EXTRACT is set, but osm is not in PLACES_PROVIDERS",
+				`ADMIN_USERS: "sam" is not user:password`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c := validConfig()
+		test.Change(&c)
+		err := c.Validate(test.Serving)
+		if test.Expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.Name, err)
+			}
+			continue
+		}
+		if problems, ok := err.(ConfigError); !ok || !reflect.DeepEqual([]string(problems), test.Expected) {
+			t.Errorf("%s: expected %q, got %v", test.Name, test.Expected, err)
+		}
+	}
+}
@@ -2,35 +2,317 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-func GetPlacesFromDB(DB *sql.DB, location Location) ([]Place, error) {
+const (
+	errPlaceExists = "place already exists"
+	// nearbyCandidates is how many ranked places Nearby returns, leaving room
+	// for closed places to be filtered out.
+	nearbyCandidates = 20
+)
+
+// placeColumns are read by scanPlace, from places aliased as p.
+const placeColumns = `p.googleid, p.name, p.location[1], p.location[0], p.status, p.curator_score,
+	p.cuisines, p.dietary, COALESCE(p.price_level, 0), p.note, p.blurb, p.recommended_dish,
+	p.opening_hours, COALESCE(p.utc_offset, 0), p.timezone, p.hours_updated_at, p.types`
+
+// PlaceRepository reads and writes curated places in Postgres.
+type PlaceRepository struct {
+	DB *sql.DB
+}
+
+type BoundingBox struct {
+	SouthWest Location
+	NorthEast Location
+}
+
+// PlaceQuery filters and pages through curated places. Empty fields match
+// everything.
+type PlaceQuery struct {
+	Name   string
+	Bounds *BoundingBox
+	Status string
+	Limit  int
+	Offset int
+}
+
+// SearchOptions narrow the curated places offered to a user. Zero values
+// don't filter.
+type SearchOptions struct {
+	// Cuisines matches places tagged with any of them.
+	Cuisines []string
+	// Dietary matches places flagged with all of them.
+	Dietary []string
+	// MaxPriceLevel is 1 (cheap) to 4 (very expensive); places without a
+	// price level always match.
+	MaxPriceLevel int
+	// Type is a Google place type such as cafe or bar.
+	Type string
+}
+
+func NewPlaceRepository(DB *sql.DB) PlaceRepository {
+	return PlaceRepository{DB: DB}
+}
+
+// Nearby returns the best approved places around location matching opts,
+// ranked by weights, best first.
+func (repo PlaceRepository) Nearby(location Location, weights RankingWeights, opts SearchOptions) ([]Place, error) {
 	var places []Place
 
-	sqlStatement := fmt.Sprintf("SELECT googleid, name FROM places WHERE location <@> POINT(%v, %v) < 0.5/1.6;", location.Longitude, location.Latitude)
-	rows, err := DB.Query(sqlStatement)
+	sqlStatement := `SELECT ` + placeColumns + `, COALESCE(f.score, 0) FROM places p
+		LEFT JOIN (SELECT googleid, SUM(score) AS score FROM feedback GROUP BY googleid) f ON f.googleid = p.googleid
+		WHERE p.status = 'approved' AND ` + withinRadiusSQL + `
+			AND (cardinality($8::text[]) = 0 OR p.cuisines && $8)
+			AND p.dietary @> $9
+			AND ($10 = 0 OR p.price_level IS NULL OR p.price_level <= $10)
+			AND ($11 = '' OR $11 = ANY(p.types))
+		ORDER BY ` + rankingSQL + ` DESC
+		LIMIT $7;`
+	rows, err := repo.DB.Query(sqlStatement, location.Longitude, location.Latitude,
+		weights.Distance, weights.CuratorScore, weights.Feedback, weights.Freshness, nearbyCandidates,
+		pq.Array(nonNil(opts.Cuisines)), pq.Array(nonNil(opts.Dietary)), opts.MaxPriceLevel, opts.Type)
 	if err != nil {
 		return nil, err
 	}
-	count := 0
 
 	defer rows.Close()
 	for rows.Next() {
-		if count < 3 {
-			var place Place
-			if err := rows.Scan(&place.ID, &place.Name); err != nil {
-				return nil, err
-			}
-			places = append(places, place)
+		var feedback int
+		place, err := scanPlace(rows, &feedback)
+		if err != nil {
+			return nil, err
 		}
-		count++
-
+		place.FeedbackScore = feedback
+		places = append(places, place)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return places, nil
 }
+
+// InsertPending stores a submitted recommendation for moderation, recording
+// who submitted it. Places that are already curated are left alone.
+func (repo PlaceRepository) InsertPending(place Place, actor string) error {
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`INSERT INTO places (googleid, name, location, status) VALUES ($1, $2, POINT($3, $4), 'pending')
+			ON CONFLICT (googleid) DO NOTHING;`,
+			place.ID, place.Name, place.Location.Longitude, place.Location.Latitude)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return insertAudit(tx, place.ID, actor, "submit", map[string]interface{}{"place": place})
+	})
+}
+
+func (repo PlaceRepository) Get(placeID string) (Place, error) {
+	place, err := scanPlace(repo.DB.QueryRow(`SELECT `+placeColumns+` FROM places p WHERE p.googleid = $1;`, placeID))
+	if err == sql.ErrNoRows {
+		return Place{}, errors.New(errPlaceNotFound)
+	}
+	return place, err
+}
+
+// List returns a page of places matching q along with the total number of
+// matches.
+func (repo PlaceRepository) List(q PlaceQuery) ([]Place, int, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.Name != "" {
+		where = append(where, "p.name ILIKE "+arg("%"+q.Name+"%"))
+	}
+	if q.Status != "" {
+		where = append(where, "p.status = "+arg(q.Status))
+	}
+	if q.Bounds != nil {
+		where = append(where, fmt.Sprintf("p.location <@ box(POINT(%s, %s), POINT(%s, %s))",
+			arg(q.Bounds.SouthWest.Longitude), arg(q.Bounds.SouthWest.Latitude),
+			arg(q.Bounds.NorthEast.Longitude), arg(q.Bounds.NorthEast.Latitude)))
+	}
+	filter := ""
+	if len(where) > 0 {
+		filter = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := repo.DB.QueryRow("SELECT count(*) FROM places p "+filter+";", args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset := arg(q.Limit), arg(q.Offset)
+	rows, err := repo.DB.Query(`SELECT `+placeColumns+` FROM places p `+filter+`
+		ORDER BY p.name, p.googleid LIMIT `+limit+` OFFSET `+offset+`;`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var places []Place
+	for rows.Next() {
+		place, err := scanPlace(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		places = append(places, place)
+	}
+	return places, total, rows.Err()
+}
+
+func (repo PlaceRepository) Create(place Place, actor string) error {
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`INSERT INTO places (`+placeWriteColumns+`)
+			VALUES (`+placeWriteValues+`) ON CONFLICT (googleid) DO NOTHING;`, placeWriteArgs(place)...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return errors.New(errPlaceExists)
+		}
+		return insertAudit(tx, place.ID, actor, "create", map[string]interface{}{"place": place})
+	})
+}
+
+func (repo PlaceRepository) Update(place Place, actor string) error {
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE places SET (`+placeWriteColumns+`) = (`+placeWriteValues+`)
+			WHERE googleid = $1;`, placeWriteArgs(place)...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return errors.New(errPlaceNotFound)
+		}
+		return insertAudit(tx, place.ID, actor, "update", map[string]interface{}{"place": place})
+	})
+}
+
+func (repo PlaceRepository) Delete(placeID, actor string) error {
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		res, err := tx.Exec("DELETE FROM places WHERE googleid = $1;", placeID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return errors.New(errPlaceNotFound)
+		}
+		return insertAudit(tx, placeID, actor, "delete", map[string]interface{}{})
+	})
+}
+
+// Upsert creates a place or overwrites the curated fields of an existing one,
+// keeping those the place leaves out; see placeUpsertSet.
+func (repo PlaceRepository) Upsert(place Place, actor string) error {
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO places (`+placeWriteColumns+`) VALUES (`+placeWriteValues+`)
+			ON CONFLICT (googleid) DO UPDATE SET `+placeUpsertSet+`;`,
+			placeWriteArgs(place)...)
+		if err != nil {
+			return err
+		}
+		return insertAudit(tx, place.ID, actor, "import", map[string]interface{}{"place": place})
+	})
+}
+
+// placeWriteColumns are the curated fields written by Create, Update and
+// Upsert, filled from placeWriteArgs. A place without a status is approved.
+// Writing a place without opening hours marks them stale so they are fetched
+// from Google again.
+//
+// placeUpsertSet updates an existing place from an import, keeping its
+// status, curator score, timezone and opening hours when the file leaves
+// them out, so importing an older export doesn't approve rejected places or
+// forget their hours.
+const (
+	placeWriteColumns = `googleid, name, location, status, curator_score, cuisines, dietary, price_level, note, blurb, recommended_dish,
+		opening_hours, utc_offset, timezone, hours_updated_at, types`
+	placeWriteValues = `$1, $2, POINT($3, $4), COALESCE(NULLIF($5, ''), 'approved'), $6, $7, $8, NULLIF($9, 0), $10, $11, $12,
+		$13, $14, $15, CASE WHEN $13::jsonb IS NULL THEN NULL ELSE now() END, $16`
+	placeUpsertSet = `name = EXCLUDED.name, location = EXCLUDED.location,
+		status = COALESCE(NULLIF($5, ''), places.status),
+		curator_score = COALESCE(EXCLUDED.curator_score, places.curator_score),
+		cuisines = EXCLUDED.cuisines, dietary = EXCLUDED.dietary, price_level = EXCLUDED.price_level,
+		note = EXCLUDED.note, blurb = EXCLUDED.blurb, recommended_dish = EXCLUDED.recommended_dish,
+		opening_hours = COALESCE(EXCLUDED.opening_hours, places.opening_hours),
+		utc_offset = CASE WHEN EXCLUDED.opening_hours IS NULL THEN places.utc_offset ELSE EXCLUDED.utc_offset END,
+		hours_updated_at = CASE WHEN EXCLUDED.opening_hours IS NULL THEN places.hours_updated_at ELSE EXCLUDED.hours_updated_at END,
+		timezone = COALESCE(NULLIF(EXCLUDED.timezone, ''), places.timezone),
+		types = EXCLUDED.types`
+)
+
+func placeWriteArgs(place Place) []interface{} {
+	return []interface{}{
+		place.ID, place.Name, place.Location.Longitude, place.Location.Latitude, place.Status, place.CuratorScore,
+		pq.Array(nonNil(place.Cuisines)), pq.Array(nonNil(place.Dietary)), place.PriceLevel, place.Note,
+		place.Blurb, place.RecommendedDish,
+		openingHoursJSON(place.OpeningHours), place.UTCOffset, place.Timezone,
+		pq.Array(placeTypesOrDefault(place.Types)),
+	}
+}
+
+// SaveOpeningHours caches opening hours fetched from Google.
+func (repo PlaceRepository) SaveOpeningHours(place Place) error {
+	_, err := repo.DB.Exec(`UPDATE places SET opening_hours = $2, utc_offset = $3, hours_updated_at = now()
+		WHERE googleid = $1;`, place.ID, openingHoursJSON(place.OpeningHours), place.UTCOffset)
+	return err
+}
+
+func openingHoursJSON(hours *OpeningHours) interface{} {
+	if hours == nil {
+		return nil
+	}
+	buf, err := json.Marshal(hours)
+	if err != nil {
+		return nil
+	}
+	return string(buf)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPlace reads placeColumns followed by any extra destinations.
+func scanPlace(row rowScanner, extra ...interface{}) (Place, error) {
+	place := Place{Curated: true}
+	var hours []byte
+	var hoursUpdatedAt pq.NullTime
+	dest := []interface{}{
+		&place.ID, &place.Name, &place.Location.Latitude, &place.Location.Longitude, &place.Status, &place.CuratorScore,
+		pq.Array(&place.Cuisines), pq.Array(&place.Dietary), &place.PriceLevel, &place.Note,
+		&place.Blurb, &place.RecommendedDish,
+		&hours, &place.UTCOffset, &place.Timezone, &hoursUpdatedAt, pq.Array(&place.Types),
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Place{}, err
+	}
+	if hours != nil {
+		place.OpeningHours = &OpeningHours{}
+		if err := json.Unmarshal(hours, place.OpeningHours); err != nil {
+			return Place{}, err
+		}
+	}
+	place.HoursUpdatedAt = hoursUpdatedAt.Time
+	return place, nil
+}
+
+// nonNil stops pq sending a nil slice as NULL rather than an empty array.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
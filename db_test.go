@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlaceRepositoryList(t *testing.T) {
+	tests := []struct {
+		Query  PlaceQuery
+		Count  string
+		Select string
+		Args   []driver.Value
+	}{
+		{
+			Query:  PlaceQuery{Limit: 20},
+			Count:  "SELECT count(*) FROM places p ;",
+			Select: "FROM places p ORDER BY p.name, p.googleid LIMIT $1 OFFSET $2;",
+			Args:   []driver.Value{int64(20), int64(0)},
+		},
+		{
+			Query:  PlaceQuery{Name: "bar", Status: StatusApproved, Limit: 20, Offset: 40},
+			Count:  "SELECT count(*) FROM places p WHERE p.name ILIKE $1 AND p.status = $2;",
+			Select: "FROM places p WHERE p.name ILIKE $1 AND p.status = $2 ORDER BY p.name, p.googleid LIMIT $3 OFFSET $4;",
+			Args:   []driver.Value{"%bar%", "approved", int64(20), int64(40)},
+		},
+		{
+			Query: PlaceQuery{
+				Bounds: &BoundingBox{
+					SouthWest: Location{Latitude: 41.37, Longitude: 2.16},
+					NorthEast: Location{Latitude: 41.39, Longitude: 2.19},
+				},
+				Limit: 20,
+			},
+			Count:  "SELECT count(*) FROM places p WHERE p.location <@ box(POINT($1, $2), POINT($3, $4));",
+			Select: "FROM places p WHERE p.location <@ box(POINT($1, $2), POINT($3, $4)) ORDER BY p.name, p.googleid LIMIT $5 OFFSET $6;",
+			Args:   []driver.Value{2.16, 41.37, 2.19, 41.39, int64(20), int64(0)},
+		},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t,
+			fakeRows{Columns: []string{"count"}, Values: [][]driver.Value{{int64(2)}}},
+			fakeRows{Values: [][]driver.Value{curatedRow("a", "Bar Marsella"), curatedRow("b", "Bar del Pla")}},
+		)
+		places, total, err := NewPlaceRepository(db).List(test.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 || len(places) != 2 || places[1].ID != "b" {
+			t.Errorf("expected 2 places of 2, got %v of %d", places, total)
+		}
+
+		queries := fake.Queries()
+		if len(queries) != 2 {
+			t.Fatalf("expected a count and a select, got %v", queries)
+		}
+		if queries[0] != test.Count {
+			t.Errorf("expected count %q, got %q", test.Count, queries[0])
+		}
+		if expected := "SELECT " + squashSpace(placeColumns) + " " + test.Select; queries[1] != expected {
+			t.Errorf("expected select %q, got %q", expected, queries[1])
+		}
+		if args := fake.Statements[1].Args; !reflect.DeepEqual(args, test.Args) {
+			t.Errorf("expected args %v, got %v", test.Args, args)
+		}
+	}
+}
+
+func TestPlaceRepositoryWrites(t *testing.T) {
+	place := Place{ID: "a", Name: "Bar Marsella", Location: Location{Latitude: 41.38, Longitude: 2.18}, Status: StatusApproved, CuratorScore: floatPtr(4), PriceLevel: 2}
+	placeArgs := []driver.Value{"a", "Bar Marsella", 2.18, 41.38, "approved", 4.0, "{}", "{}", int64(2), "", "", "",
+		nil, int64(0), "", `{"restaurant"}`}
+
+	tests := []struct {
+		Name      string
+		Write     func(PlaceRepository) error
+		Affected  int64
+		Statement string
+		Args      []driver.Value
+		Action    string
+		Err       string
+	}{
+		{
+			Name:      "create",
+			Write:     func(repo PlaceRepository) error { return repo.Create(place, "nicola") },
+			Affected:  1,
+			Statement: "INSERT INTO places (" + squashSpace(placeWriteColumns) + ") VALUES (" + squashSpace(placeWriteValues) + ") ON CONFLICT (googleid) DO NOTHING;",
+			Args:      placeArgs,
+			Action:    "create",
+		},
+		{
+			Name:      "create existing",
+			Write:     func(repo PlaceRepository) error { return repo.Create(place, "nicola") },
+			Statement: "INSERT INTO places (" + squashSpace(placeWriteColumns) + ") VALUES (" + squashSpace(placeWriteValues) + ") ON CONFLICT (googleid) DO NOTHING;",
+			Args:      placeArgs,
+			Err:       errPlaceExists,
+		},
+		{
+			Name:      "update",
+			Write:     func(repo PlaceRepository) error { return repo.Update(place, "nicola") },
+			Affected:  1,
+			Statement: "UPDATE places SET (" + squashSpace(placeWriteColumns) + ") = (" + squashSpace(placeWriteValues) + ") WHERE googleid = $1;",
+			Args:      placeArgs,
+			Action:    "update",
+		},
+		{
+			Name:      "update missing",
+			Write:     func(repo PlaceRepository) error { return repo.Update(place, "nicola") },
+			Statement: "UPDATE places SET (" + squashSpace(placeWriteColumns) + ") = (" + squashSpace(placeWriteValues) + ") WHERE googleid = $1;",
+			Args:      placeArgs,
+			Err:       errPlaceNotFound,
+		},
+		{
+			Name:      "delete",
+			Write:     func(repo PlaceRepository) error { return repo.Delete("a", "nicola") },
+			Affected:  1,
+			Statement: "DELETE FROM places WHERE googleid = $1;",
+			Args:      []driver.Value{"a"},
+			Action:    "delete",
+		},
+		{
+			Name:      "delete missing",
+			Write:     func(repo PlaceRepository) error { return repo.Delete("a", "nicola") },
+			Statement: "DELETE FROM places WHERE googleid = $1;",
+			Args:      []driver.Value{"a"},
+			Err:       errPlaceNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t)
+		fake.Affected = []int64{test.Affected}
+		err := test.Write(NewPlaceRepository(db))
+		if test.Err != "" {
+			if err == nil || err.Error() != test.Err {
+				t.Errorf("expected %s to fail with %q, got %v", test.Name, test.Err, err)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error for %s: %s", test.Name, err)
+		}
+
+		queries := fake.Queries()
+		if len(queries) == 0 || queries[0] != test.Statement {
+			t.Errorf("expected %s to run %q, got %v", test.Name, test.Statement, queries)
+			continue
+		}
+		if args := fake.Statements[0].Args; !reflect.DeepEqual(args, test.Args) {
+			t.Errorf("expected %s args %v, got %v", test.Name, test.Args, args)
+		}
+		if test.Action == "" {
+			if len(queries) != 1 {
+				t.Errorf("expected failed %s not to be audited, got %v", test.Name, queries)
+			}
+			continue
+		}
+		audit := fake.Last()
+		if len(queries) != 2 || audit.Args[1] != "nicola" || audit.Args[2] != test.Action {
+			t.Errorf("expected %s to be audited, got %v", test.Name, audit)
+		}
+	}
+}
+
+func TestPlaceRepositoryUpsertKeepsMissingFields(t *testing.T) {
+	db, fake := newFakeDB(t)
+	place := Place{ID: "a", Name: "Bar Marsella", Location: Location{Latitude: 41.38, Longitude: 2.18}}
+
+	if err := NewPlaceRepository(db).Upsert(place, "nicola"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	upsert := fake.Queries()[0]
+	for _, keep := range []string{
+		"status = COALESCE(NULLIF($5, ''), places.status)",
+		"curator_score = COALESCE(EXCLUDED.curator_score, places.curator_score)",
+		"opening_hours = COALESCE(EXCLUDED.opening_hours, places.opening_hours)",
+		"timezone = COALESCE(NULLIF(EXCLUDED.timezone, ''), places.timezone)",
+	} {
+		if !strings.Contains(upsert, keep) {
+			t.Errorf("expected upsert to keep existing fields with %q, got %s", keep, upsert)
+		}
+	}
+	if args := fake.Statements[0].Args; args[4] != "" || args[5] != nil {
+		t.Errorf("expected no status or curator score to be sent, got %v", args)
+	}
+}
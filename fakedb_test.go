@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver, in the spirit of sqlmock, that records
+// the statements run on it and answers queries with canned rows, so the SQL
+// we generate can be tested without Postgres.
+type fakeDB struct {
+	sync.Mutex
+	Statements []fakeStatement
+	// Rows are returned by successive queries; later queries return none.
+	Rows []fakeRows
+	// Affected are the rows affected by successive execs; later ones affect
+	// one row.
+	Affected []int64
+}
+
+type fakeStatement struct {
+	Query string
+	Args  []driver.Value
+}
+
+type fakeRows struct {
+	// Columns may be left out when the code under test scans by position.
+	Columns []string
+	Values  [][]driver.Value
+}
+
+var fakeDBs = struct {
+	sync.Mutex
+	byName map[string]*fakeDB
+}{byName: make(map[string]*fakeDB)}
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB opens a database answering queries with rows, in order.
+func newFakeDB(t *testing.T, rows ...fakeRows) (*sql.DB, *fakeDB) {
+	f := &fakeDB{Rows: rows}
+	fakeDBs.Lock()
+	name := fmt.Sprintf("%s#%d", t.Name(), len(fakeDBs.byName))
+	fakeDBs.byName[name] = f
+	fakeDBs.Unlock()
+	db, err := sql.Open("fakedb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, f
+}
+
+// Queries returns the statements run, with whitespace collapsed.
+func (f *fakeDB) Queries() []string {
+	f.Lock()
+	defer f.Unlock()
+	var queries []string
+	for _, s := range f.Statements {
+		queries = append(queries, squashSpace(s.Query))
+	}
+	return queries
+}
+
+// Last returns the last statement run, with whitespace collapsed.
+func (f *fakeDB) Last() fakeStatement {
+	f.Lock()
+	defer f.Unlock()
+	if len(f.Statements) == 0 {
+		return fakeStatement{}
+	}
+	s := f.Statements[len(f.Statements)-1]
+	return fakeStatement{Query: squashSpace(s.Query), Args: s.Args}
+}
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func squashSpace(s string) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBs.Lock()
+	defer fakeDBs.Unlock()
+	f, ok := fakeDBs.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %q", name)
+	}
+	return fakeConn{f}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+	s.db.Statements = append(s.db.Statements, fakeStatement{Query: s.query, Args: args})
+	affected := int64(1)
+	if len(s.db.Affected) > 0 {
+		affected, s.db.Affected = s.db.Affected[0], s.db.Affected[1:]
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+	s.db.Statements = append(s.db.Statements, fakeStatement{Query: s.query, Args: args})
+	if len(s.db.Rows) == 0 {
+		return &fakeRowsIter{}, nil
+	}
+	rows := s.db.Rows[0]
+	s.db.Rows = s.db.Rows[1:]
+	return &fakeRowsIter{rows: rows}, nil
+}
+
+type fakeRowsIter struct {
+	rows fakeRows
+	next int
+}
+
+func (r *fakeRowsIter) Close() error { return nil }
+
+// Columns names the columns of the rows, making names up if they weren't
+// given.
+func (r *fakeRowsIter) Columns() []string {
+	if r.rows.Columns != nil || len(r.rows.Values) == 0 {
+		return r.rows.Columns
+	}
+	columns := make([]string, len(r.rows.Values[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column%d", i+1)
+	}
+	return columns
+}
+
+func (r *fakeRowsIter) Next(dest []driver.Value) error {
+	if r.next == len(r.rows.Values) {
+		return io.EOF
+	}
+	copy(dest, r.rows.Values[r.next])
+	r.next++
+	return nil
+}
+
+// curatedRow is a row of placeColumns for an approved place, followed by
+// extra columns.
+func curatedRow(id, name string, extra ...driver.Value) []driver.Value {
+	row := []driver.Value{id, name, 41.38, 2.17, "approved", 0.0,
+		"{}", "{}", int64(0), "", "", "",
+		nil, int64(0), "", nil, "{restaurant}"}
+	return append(row, extra...)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	feedbackUpPayload    = "FEEDBACK_UP"
+	feedbackDownPayload  = "FEEDBACK_DOWN"
+	defaultFeedbackDelay = time.Hour
+	// feedbackPollInterval is how often due feedback requests are sent.
+	feedbackPollInterval = time.Minute
+	feedbackBatchSize    = 100
+	// feedbackClaimTimeout is how long a claimed feedback request waits for
+	// its send before another poll may claim it again.
+	feedbackClaimTimeout = 10 * time.Minute
+	errInvalidFeedback   = "invalid feedback payload"
+	errInvalidLink       = "invalid link signature"
+)
+
+type Feedback struct {
+	PlaceID string
+	UserID  string
+	Score   int
+}
+
+// DirectionsHandler records that a user opened directions to a place,
+// schedules a follow-up asking whether they liked it and redirects to Google
+// Maps. Links naming a user must be signed, so only the bot can make them.
+func (app *App) DirectionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	place := Place{ID: query.Get("place")}
+	if place.ID == "" {
+		http.Error(w, "missing place", http.StatusBadRequest)
+		return
+	}
+	if providerName(place.ID) != providerGoogle {
+		lat, latErr := strconv.ParseFloat(query.Get("lat"), 64)
+		lng, lngErr := strconv.ParseFloat(query.Get("lng"), 64)
+		location, err := NewLocation(lat, lng)
+		if latErr != nil || lngErr != nil || err != nil {
+			http.Error(w, ErrInvalidCoordinates, http.StatusBadRequest)
+			return
+		}
+		place.Location = *location
+	}
+	if user := query.Get("user"); user != "" {
+		if !hmac.Equal([]byte(query.Get("sig")), []byte(signLink(app.Config.LinkSecret, place.ID, user))) {
+			http.Error(w, errInvalidLink, http.StatusForbidden)
+			return
+		}
+		sendAt := time.Now().Add(app.Config.FeedbackDelay)
+		if err := ScheduleFeedbackRequest(app.DB, user, place.ID, sendAt); err != nil {
+			log.Println("error scheduling feedback request: ", err)
+		}
+	}
+	http.Redirect(w, r, place.LinkMapUrl(), http.StatusFound)
+}
+
+// signLink returns the hex HMAC-SHA256 of a directions link's place and user.
+func signLink(secret, placeID, user string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(placeID + "\n" + user))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ScheduleFeedbackRequest stores a request to ask the user about a place at
+// sendAt, replacing any earlier one for the same place.
+func ScheduleFeedbackRequest(DB *sql.DB, user, placeID string, sendAt time.Time) error {
+	_, err := DB.Exec(`INSERT INTO feedback_requests (psid, googleid, send_at) VALUES ($1, $2, $3)
+		ON CONFLICT (psid, googleid) DO UPDATE SET send_at = EXCLUDED.send_at;`,
+		user, placeID, sendAt)
+	return err
+}
+
+// ClaimDueFeedbackRequests claims and returns up to limit requests due by now.
+// Rows locked by another instance are skipped, and claimed rows aren't
+// returned again until feedbackClaimTimeout has passed, so a request whose
+// send failed or never finished is retried later.
+func ClaimDueFeedbackRequests(DB *sql.DB, now time.Time, limit int) ([]Feedback, error) {
+	rows, err := DB.Query(`UPDATE feedback_requests SET claimed_at = $1 WHERE (psid, googleid) IN (
+			SELECT psid, googleid FROM feedback_requests
+			WHERE send_at <= $1 AND (claimed_at IS NULL OR claimed_at <= $2)
+			ORDER BY send_at LIMIT $3 FOR UPDATE SKIP LOCKED)
+		RETURNING psid, googleid;`, now, now.Add(-feedbackClaimTimeout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var requests []Feedback
+	for rows.Next() {
+		var f Feedback
+		if err := rows.Scan(&f.UserID, &f.PlaceID); err != nil {
+			return nil, err
+		}
+		requests = append(requests, f)
+	}
+	return requests, rows.Err()
+}
+
+// DeleteFeedbackRequest removes a feedback request once it has been dealt
+// with.
+func DeleteFeedbackRequest(DB *sql.DB, f Feedback) error {
+	_, err := DB.Exec(`DELETE FROM feedback_requests WHERE psid = $1 AND googleid = $2;`, f.UserID, f.PlaceID)
+	return err
+}
+
+// SendFeedbackRequests sends due feedback requests every interval, forever.
+func (app *App) SendFeedbackRequests(interval time.Duration) {
+	for range time.Tick(interval) {
+		app.sendDueFeedbackRequests(time.Now())
+	}
+}
+
+func (app *App) sendDueFeedbackRequests(now time.Time) {
+	for {
+		requests, err := ClaimDueFeedbackRequests(app.DB, now, feedbackBatchSize)
+		if err != nil {
+			log.Println("error getting due feedback requests: ", err)
+			return
+		}
+		for _, f := range requests {
+			if err := app.sendFeedbackRequest(f.UserID, f.PlaceID); err != nil && isTemporarySendError(err) {
+				continue
+			}
+			if err := DeleteFeedbackRequest(app.DB, f); err != nil {
+				log.Println("error deleting feedback request: ", err)
+			}
+		}
+		if len(requests) < feedbackBatchSize {
+			return
+		}
+	}
+}
+
+// sendFeedbackRequest asks the user whether they liked a place, unless they
+// have blocked the page.
+func (app *App) sendFeedbackRequest(user, placeID string) error {
+	if u, err := app.Users.Get(user); err != nil {
+		log.Println("error getting user: ", err)
+	} else if u.Blocked {
+		return nil
+	}
+	message := FBMessage{
+		Text: "Did you like it?",
+		QuickReplies: []FBQuickReply{
+			{
+				ContentType: "text",
+				Title:       "👍",
+				Payload:     fmt.Sprintf("%s:%s", feedbackUpPayload, placeID),
+			},
+			{
+				ContentType: "text",
+				Title:       "👎",
+				Payload:     fmt.Sprintf("%s:%s", feedbackDownPayload, placeID),
+			},
+		},
+	}
+	err := app.Messenger.Send(user, message)
+	if err != nil {
+		app.sendFailed(user, "feedback request", err)
+	}
+	return err
+}
+
+// isTemporarySendError reports whether a failed send is worth trying again
+// later: rate limits, and failures that never got an answer from the Graph
+// API, like a lost connection.
+func isTemporarySendError(err error) bool {
+	switch err.(type) {
+	case *GraphError:
+		return retryable(err)
+	case UserBlockedError:
+		return false
+	}
+	return true
+}
+
+func parseFeedbackPayload(user, payload string) (Feedback, error) {
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Feedback{}, errors.New(errInvalidFeedback)
+	}
+
+	f := Feedback{
+		PlaceID: parts[1],
+		UserID:  user,
+	}
+	switch parts[0] {
+	case feedbackUpPayload:
+		f.Score = 1
+	case feedbackDownPayload:
+		f.Score = -1
+	default:
+		return Feedback{}, errors.New(errInvalidFeedback)
+	}
+	return f, nil
+}
+
+// SaveFeedback stores a user's verdict on a place, replacing any earlier one
+// so each user counts once towards the place's score.
+func SaveFeedback(DB *sql.DB, f Feedback) error {
+	_, err := DB.Exec(`INSERT INTO feedback (googleid, psid, score) VALUES ($1, $2, $3)
+		ON CONFLICT (googleid, psid) DO UPDATE SET score = EXCLUDED.score, created_at = now();`,
+		f.PlaceID, f.UserID, f.Score)
+	return err
+}
+
+// DirectionsUrl links to the app's directions handler at appURL, which
+// redirects to the map, signed with secret. Places not from Google carry
+// their location, as the map can't find them by ID.
+func (p *Place) DirectionsUrl(appURL, secret, user string) string {
+	query := url.Values{}
+	query.Set("place", p.ID)
+	query.Set("user", user)
+	query.Set("sig", signLink(secret, p.ID, user))
+	if providerName(p.ID) != providerGoogle {
+		query.Set("lat", fmt.Sprint(p.Location.Latitude))
+		query.Set("lng", fmt.Sprint(p.Location.Longitude))
+	}
+	return fmt.Sprintf("%s/directions?%s", appURL, query.Encode())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeedbackPayload(t *testing.T) {
+	tests := []struct {
+		Payload  string
+		Expected Feedback
+		Valid    bool
+	}{
+		{
+			Payload:  "FEEDBACK_UP:rgejh446wrsDGNRmsw5",
+			Expected: Feedback{PlaceID: "rgejh446wrsDGNRmsw5", UserID: "1234", Score: 1},
+			Valid:    true,
+		},
+		{
+			Payload:  "FEEDBACK_DOWN:rgejh446wrsDGNRmsw5",
+			Expected: Feedback{PlaceID: "rgejh446wrsDGNRmsw5", UserID: "1234", Score: -1},
+			Valid:    true,
+		},
+		{
+			Payload: "FEEDBACK_UP:",
+		},
+		{
+			Payload: "SOMETHING_ELSE:rgejh446wrsDGNRmsw5",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseFeedbackPayload("1234", test.Payload)
+		if test.Valid && err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.Payload, err)
+		}
+		if !test.Valid && err == nil {
+			t.Errorf("expected error parsing %s", test.Payload)
+		}
+		if got != test.Expected {
+			t.Errorf("expected %v, got %v", test.Expected, got)
+		}
+	}
+}
+
+func TestDirectionsHandlerRedirectsToMap(t *testing.T) {
+	req := httptest.NewRequest("GET", "/directions?place=rgejh446wrsDGNRmsw5", nil)
+	w := httptest.NewRecorder()
+
+	app := newApp(Config{FeedbackDelay: time.Hour}, nil, stubProvider{}, &fakeSender{})
+	app.DirectionsHandler(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+	expected := "https://www.google.com/maps/place/?q=place_id:rgejh446wrsDGNRmsw5"
+	if got := w.Header().Get("Location"); got != expected {
+		t.Errorf("expected redirect to %s, got %s", expected, got)
+	}
+}
+
+func TestDirectionsHandlerSchedulesFeedback(t *testing.T) {
+	db, fake := newFakeDB(t)
+	app := newApp(Config{FeedbackDelay: time.Hour, LinkSecret: "s3cret"}, db, stubProvider{}, &fakeSender{})
+	place := Place{ID: "rgejh446wrsDGNRmsw5"}
+	link, _ := url.Parse(place.DirectionsUrl("https://hungry-girl.example.com", "s3cret", "1234"))
+
+	w := httptest.NewRecorder()
+	app.DirectionsHandler(w, httptest.NewRequest("GET", link.RequestURI(), nil))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+	last := fake.Last()
+	if !strings.HasPrefix(last.Query, "INSERT INTO feedback_requests") || last.Args[0] != "1234" || last.Args[1] != place.ID {
+		t.Errorf("expected a feedback request for 1234 to be stored, got %v", last)
+	}
+	if sendAt, ok := last.Args[2].(time.Time); !ok || time.Until(sendAt) < 59*time.Minute {
+		t.Errorf("expected the request to be sent in an hour, got %v", last.Args[2])
+	}
+}
+
+func TestDirectionsHandlerLinksOtherProvidersByLocation(t *testing.T) {
+	db, _ := newFakeDB(t)
+	app := newApp(Config{FeedbackDelay: time.Hour, LinkSecret: "s3cret"}, db, stubProvider{}, &fakeSender{})
+	place := Place{ID: "osm:node/123", Location: Location{Latitude: 41.3782, Longitude: 2.1718}}
+	link, _ := url.Parse(place.DirectionsUrl("https://hungry-girl.example.com", "s3cret", "1234"))
+
+	w := httptest.NewRecorder()
+	app.DirectionsHandler(w, httptest.NewRequest("GET", link.RequestURI(), nil))
+
+	expected := "https://www.google.com/maps/search/?api=1&query=41.3782,2.1718"
+	if got := w.Header().Get("Location"); w.Code != http.StatusFound || got != expected {
+		t.Errorf("expected redirect to %s, got %d %s", expected, w.Code, got)
+	}
+
+	w = httptest.NewRecorder()
+	app.DirectionsHandler(w, httptest.NewRequest("GET", "/directions?place=osm:node/123", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without a location, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDirectionsHandlerChecksSignature(t *testing.T) {
+	tests := []string{
+		"/directions?place=rgejh446wrsDGNRmsw5&user=1234",
+		"/directions?place=rgejh446wrsDGNRmsw5&user=5678&sig=" + signLink("s3cret", "rgejh446wrsDGNRmsw5", "1234"),
+		"/directions?place=rgejh446wrsDGNRmsw5&user=1234&sig=" + signLink("wrong", "rgejh446wrsDGNRmsw5", "1234"),
+	}
+
+	for _, path := range tests {
+		db, fake := newFakeDB(t)
+		app := newApp(Config{FeedbackDelay: time.Hour, LinkSecret: "s3cret"}, db, stubProvider{}, &fakeSender{})
+		w := httptest.NewRecorder()
+		app.DirectionsHandler(w, httptest.NewRequest("GET", path, nil))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status %d for %s, got %d", http.StatusForbidden, path, w.Code)
+		}
+		if len(fake.Statements) != 0 {
+			t.Errorf("expected nothing stored for %s, got %v", path, fake.Queries())
+		}
+	}
+}
+
+func TestSendDueFeedbackRequests(t *testing.T) {
+	db, fake := newFakeDB(t, fakeRows{
+		Columns: []string{"psid", "googleid"},
+		Values:  [][]driver.Value{{"1234", "place1"}, {"5678", "place2"}},
+	})
+	sender := &fakeSender{}
+	app := newApp(Config{}, db, stubProvider{}, sender)
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	app.sendDueFeedbackRequests(now)
+
+	if len(sender.Sent) != 2 || sender.Sent[1].User != "5678" || sender.Sent[1].Message.QuickReplies[0].Payload != "FEEDBACK_UP:place2" {
+		t.Errorf("expected feedback requests for both users, got %v", sender.Sent)
+	}
+	claimed := fake.Statements[0]
+	if !strings.HasPrefix(claimed.Query, "UPDATE feedback_requests SET claimed_at") || claimed.Args[0] != now ||
+		claimed.Args[1] != now.Add(-feedbackClaimTimeout) || claimed.Args[2] != int64(feedbackBatchSize) {
+		t.Errorf("expected due requests to be claimed, got %v", claimed)
+	}
+	var deleted []string
+	for _, statement := range fake.Statements[1:] {
+		if strings.HasPrefix(statement.Query, "DELETE FROM feedback_requests") {
+			deleted = append(deleted, statement.Args[0].(string))
+		}
+	}
+	if len(deleted) != 2 || deleted[0] != "1234" || deleted[1] != "5678" {
+		t.Errorf("expected both requests deleted once sent, got %v", fake.Queries())
+	}
+}
+
+func TestSendDueFeedbackRequestsKeepsFailedSends(t *testing.T) {
+	tests := []struct {
+		Err     error
+		Deleted bool
+	}{
+		{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+		{Err: &GraphError{Code: graphErrorPageRateLimit}},
+		{Err: errors.New("unexpected EOF")},
+		{Err: &GraphError{Code: graphErrorPermission}, Deleted: true},
+		{Err: UserBlockedError{User: "1234"}, Deleted: true},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t, fakeRows{
+			Columns: []string{"psid", "googleid"},
+			Values:  [][]driver.Value{{"1234", "place1"}},
+		})
+		app := newApp(Config{}, db, stubProvider{}, &fakeSender{Err: test.Err})
+		app.Users = fakeUserStore{}
+
+		app.sendDueFeedbackRequests(time.Now())
+
+		deleted := strings.HasPrefix(fake.Last().Query, "DELETE FROM feedback_requests")
+		if deleted != test.Deleted {
+			t.Errorf("expected deleted %t after %v, got %v", test.Deleted, test.Err, fake.Queries())
+		}
+	}
+}
+
+func TestSendDueFeedbackRequestsSkipsBlockedUsers(t *testing.T) {
+	db, _ := newFakeDB(t, fakeRows{
+		Columns: []string{"psid", "googleid"},
+		Values:  [][]driver.Value{{"1234", "place1"}, {"5678", "place2"}},
+	})
+	sender := &fakeSender{}
+	app := newApp(Config{}, db, stubProvider{}, sender)
+	app.Users = fakeUserStore{"1234": {PSID: "1234", Blocked: true}}
+
+	app.sendDueFeedbackRequests(time.Now())
+
+	if len(sender.Sent) != 1 || sender.Sent[0].User != "5678" {
+		t.Errorf("expected a feedback request to 5678 only, got %v", sender.Sent)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	foursquareFields = "fsq_id,name,geocodes,price,rating,website,categories,hours,photos,timezone"
+	// foursquarePhotoSize is replaced by the photo size in photo references.
+	foursquarePhotoSize = "{size}"
+)
+
+// foursquareCategories maps Google place types to Foursquare category IDs.
+var foursquareCategories = map[string]string{
+	"restaurant":    "13065",
+	"cafe":          "13032",
+	"bar":           "13003",
+	"bakery":        "13002",
+	"meal_takeaway": "13145",
+}
+
+// FoursquareClient talks to the Foursquare Places API. Timezone is used for
+// places' opening hours when Foursquare doesn't give one.
+type FoursquareClient struct {
+	BaseURL  string
+	APIKey   string
+	Timezone string
+}
+
+type foursquareSearchResponse struct {
+	Results []foursquarePlace `json:"results"`
+}
+
+type foursquarePlace struct {
+	ID       string `json:"fsq_id"`
+	Name     string `json:"name"`
+	Geocodes struct {
+		Main struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"main"`
+	} `json:"geocodes"`
+	Price int `json:"price"`
+	// Rating is out of 10.
+	Rating     float64 `json:"rating"`
+	Website    string  `json:"website"`
+	Categories []struct {
+		ID int `json:"id"`
+	} `json:"categories"`
+	Hours struct {
+		Regular []struct {
+			// Day is 1 for Monday to 7 for Sunday.
+			Day   int    `json:"day"`
+			Open  string `json:"open"`
+			Close string `json:"close"`
+		} `json:"regular"`
+	} `json:"hours"`
+	Photos []struct {
+		Prefix string `json:"prefix"`
+		Suffix string `json:"suffix"`
+	} `json:"photos"`
+	// Timezone is the IANA time zone of the place's hours.
+	Timezone string `json:"timezone"`
+}
+
+func NewFoursquareClient(c Config) FoursquareClient {
+	return FoursquareClient{
+		BaseURL:  "https://api.foursquare.com/v3",
+		APIKey:   c.FoursquareAPIKey,
+		Timezone: c.PlacesTimezone,
+	}
+}
+
+func (client FoursquareClient) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	placeType := search.Type
+	if placeType == "" {
+		placeType = defaultPlaceType
+	}
+	params := url.Values{}
+	params.Set("ll", fmt.Sprintf("%v,%v", l.Latitude, l.Longitude))
+	params.Set("radius", "500")
+	params.Set("categories", foursquareCategories[placeType])
+	client.setFilters(params, search.OpenNow, search.MinPrice, search.MaxPrice, search.Limit)
+	return client.search(params)
+}
+
+func (client FoursquareClient) TextSearch(q TextQuery) ([]Place, error) {
+	params := url.Values{}
+	params.Set("query", q.Query)
+	if q.Location != nil {
+		radius := q.Radius
+		if radius == 0 {
+			radius = textSearchRadius
+		}
+		params.Set("ll", fmt.Sprintf("%v,%v", q.Location.Latitude, q.Location.Longitude))
+		params.Set("radius", strconv.Itoa(radius))
+	}
+	if category, ok := foursquareCategories[q.Type]; ok {
+		params.Set("categories", category)
+	}
+	client.setFilters(params, q.OpenNow, q.MinPrice, q.MaxPrice, q.Limit)
+	return client.search(params)
+}
+
+func (client FoursquareClient) setFilters(params url.Values, openNow bool, minPrice, maxPrice, limit int) {
+	if openNow {
+		params.Set("open_now", "true")
+	}
+	if minPrice > 0 {
+		params.Set("min_price", strconv.Itoa(minPrice))
+	}
+	if maxPrice > 0 {
+		params.Set("max_price", strconv.Itoa(maxPrice))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	params.Set("fields", foursquareFields)
+}
+
+func (client FoursquareClient) search(params url.Values) ([]Place, error) {
+	var resp foursquareSearchResponse
+	if err := getJSON(client.BaseURL+"/places/search?"+params.Encode(), client.header(), &resp); err != nil {
+		return nil, err
+	}
+	var p []Place
+	for _, result := range resp.Results {
+		p = append(p, result.place(client.Timezone))
+	}
+	return p, nil
+}
+
+func (client FoursquareClient) Details(placeID string) (Place, error) {
+	id := strings.TrimPrefix(placeID, providerIDPrefixes[providerFoursquare])
+	var result foursquarePlace
+	err := getJSON(fmt.Sprintf("%s/places/%s?fields=%s", client.BaseURL, url.PathEscape(id), foursquareFields), client.header(), &result)
+	if err != nil {
+		return Place{}, err
+	}
+	return result.place(client.Timezone), nil
+}
+
+// Photo sizes a photo reference; Foursquare photo URLs need no key.
+func (client FoursquareClient) Photo(reference string, maxWidth int) (string, error) {
+	reference = strings.TrimPrefix(reference, providerIDPrefixes[providerFoursquare])
+	if !strings.Contains(reference, foursquarePhotoSize) {
+		return "", errors.New("invalid Foursquare photo reference")
+	}
+	return strings.Replace(reference, foursquarePhotoSize, fmt.Sprintf("width%d", maxWidth), 1), nil
+}
+
+func (client FoursquareClient) header() http.Header {
+	return http.Header{
+		"Authorization": {client.APIKey},
+		"Accept":        {"application/json"},
+	}
+}
+
+// place converts a Foursquare result, whose hours are in its own time zone
+// or, if it doesn't give one, in timezone.
+func (result foursquarePlace) place(timezone string) Place {
+	prefix := providerIDPrefixes[providerFoursquare]
+	place := Place{
+		ID:         prefix + result.ID,
+		Name:       result.Name,
+		Website:    result.Website,
+		Rating:     result.Rating / 2,
+		Location:   Location{Latitude: result.Geocodes.Main.Latitude, Longitude: result.Geocodes.Main.Longitude},
+		PriceLevel: result.Price,
+		Timezone:   result.Timezone,
+	}
+	if place.Timezone == "" {
+		place.Timezone = timezone
+	}
+	for _, c := range result.Categories {
+		for placeType, category := range foursquareCategories {
+			if strconv.Itoa(c.ID) == category {
+				place.Types = append(place.Types, placeType)
+			}
+		}
+	}
+	for _, photo := range result.Photos {
+		place.Photos = append(place.Photos, PlacePhoto{Reference: prefix + photo.Prefix + foursquarePhotoSize + photo.Suffix})
+	}
+	if len(result.Hours.Regular) > 0 {
+		place.OpeningHours = &OpeningHours{}
+		for _, h := range result.Hours.Regular {
+			if period, ok := dailyPeriod(h.Day%7, h.Open, h.Close); ok {
+				place.OpeningHours.Periods = append(place.OpeningHours.Periods, period)
+			}
+		}
+	}
+	return place
+}
+
+// dailyPeriod is an opening from open to close "hhmm" on day (0 is Sunday),
+// closing the next day if close isn't after open. Some providers prefix times
+// past midnight with "+".
+func dailyPeriod(day int, open, close string) (OpeningPeriod, bool) {
+	close = strings.TrimPrefix(close, "+")
+	period := OpeningPeriod{
+		Open:  DayTime{Day: day, Time: open},
+		Close: &DayTime{Day: day, Time: close},
+	}
+	if _, err := period.Open.minuteOfWeek(); err != nil {
+		return OpeningPeriod{}, false
+	}
+	if _, err := period.Close.minuteOfWeek(); err != nil {
+		return OpeningPeriod{}, false
+	}
+	if close <= open {
+		period.Close.Day = (day + 1) % 7
+	}
+	return period, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const foursquareSearchFixture = `{
+  "results": [
+    {
+      "fsq_id": "4b058804f964a520",
+      "name": "Bar Marsella",
+      "geocodes": {"main": {"latitude": 41.3782, "longitude": 2.1718}},
+      "price": 1,
+      "rating": 8.6,
+      "website": "http://www.example.com",
+      "categories": [{"id": 13003, "name": "Bar"}],
+      "hours": {"regular": [{"day": 6, "open": "2200", "close": "+0230"}]},
+      "photos": [{"prefix": "https://fastly.4sqi.net/img/general/", "suffix": "/1_abc.jpg"}],
+      "timezone": "Europe/Madrid"
+    }
+  ]
+}`
+
+func TestFoursquareNearby(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Header.Get("Authorization") != "key" || r.URL.Path != "/places/search" {
+			t.Errorf("unexpected request %s with %v", r.URL.Path, r.Header)
+		}
+		if query.Get("ll") != "41.38,2.17" || query.Get("categories") != "13003" || query.Get("max_price") != "2" || query.Get("open_now") != "true" {
+			t.Errorf("unexpected search parameters %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(foursquareSearchFixture))
+	}))
+	defer server.Close()
+	client := FoursquareClient{BaseURL: server.URL, APIKey: "key", Timezone: "Europe/London"}
+
+	places, err := client.Nearby(Location{Latitude: 41.38, Longitude: 2.17}, NearbySearch{OpenNow: true, Limit: 3, Type: "bar", MaxPrice: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []Place{{
+		ID:         "fsq:4b058804f964a520",
+		Name:       "Bar Marsella",
+		Website:    "http://www.example.com",
+		Rating:     4.3,
+		Location:   Location{Latitude: 41.3782, Longitude: 2.1718},
+		PriceLevel: 1,
+		Types:      []string{"bar"},
+		Photos:     []PlacePhoto{{Reference: "fsq:https://fastly.4sqi.net/img/general/{size}/1_abc.jpg"}},
+		OpeningHours: &OpeningHours{Periods: []OpeningPeriod{
+			{Open: DayTime{Day: 6, Time: "2200"}, Close: &DayTime{Day: 0, Time: "0230"}},
+		}},
+		Timezone: "Europe/Madrid",
+	}}
+	if !reflect.DeepEqual(expected, places) {
+		t.Errorf("expected %+v, got %+v", expected, places)
+	}
+
+	url, err := client.Photo(places[0].Photos[0].Reference, photoMaxWidth)
+	if err != nil || url != "https://fastly.4sqi.net/img/general/width400/1_abc.jpg" {
+		t.Errorf("expected sized photo url, got %s, %v", url, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+	// openingHoursTTL is how long opening hours fetched from Google are
+	// trusted before being fetched again.
+	openingHoursTTL = 7 * 24 * time.Hour
+)
+
+// OpeningHours mirrors the opening_hours object from Google Places details.
+type OpeningHours struct {
+	Periods []OpeningPeriod `json:"periods"`
+}
+
+// OpeningPeriod is one opening, e.g. Tuesday 18:00 to Wednesday 01:00. A
+// period with no close is open around the clock.
+type OpeningPeriod struct {
+	Open  DayTime  `json:"open"`
+	Close *DayTime `json:"close,omitempty"`
+}
+
+// DayTime is a day of the week (0 is Sunday) and a 24 hour "hhmm" time.
+type DayTime struct {
+	Day  int    `json:"day"`
+	Time string `json:"time"`
+}
+
+// minuteOfWeek counts minutes from midnight at the start of Sunday. Google
+// sometimes closes places at "2400", which is taken to be midnight at the
+// start of the next day.
+func (d DayTime) minuteOfWeek() (int, error) {
+	if d.Day < 0 || d.Day > 6 || len(d.Time) != 4 {
+		return 0, fmt.Errorf("invalid opening time %d %q", d.Day, d.Time)
+	}
+	hhmm, err := strconv.Atoi(d.Time)
+	if err != nil || hhmm > 2400 || hhmm%100 > 59 {
+		return 0, fmt.Errorf("invalid opening time %d %q", d.Day, d.Time)
+	}
+	return (d.Day*minutesPerDay + hhmm/100*60 + hhmm%100) % minutesPerWeek, nil
+}
+
+func minuteOfWeek(t time.Time) int {
+	return int(t.Weekday())*minutesPerDay + t.Hour()*60 + t.Minute()
+}
+
+// IsOpenAt reports whether any period covers t, which should already be in
+// the place's time zone.
+func (h OpeningHours) IsOpenAt(t time.Time) bool {
+	now := minuteOfWeek(t)
+	for _, p := range h.Periods {
+		if p.Close == nil {
+			return true
+		}
+		opens, err := p.Open.minuteOfWeek()
+		if err != nil {
+			continue
+		}
+		closes, err := p.Close.minuteOfWeek()
+		if err != nil {
+			continue
+		}
+		if closes <= opens {
+			// The period runs past the end of Saturday.
+			closes += minutesPerWeek
+		}
+		if (now >= opens && now < closes) || (now+minutesPerWeek >= opens && now+minutesPerWeek < closes) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns when the place next opens after t, if it ever does.
+func (h OpeningHours) NextOpening(t time.Time) (time.Time, bool) {
+	now := minuteOfWeek(t)
+	best := -1
+	for _, p := range h.Periods {
+		opens, err := p.Open.minuteOfWeek()
+		if err != nil {
+			continue
+		}
+		wait := (opens - now + minutesPerWeek) % minutesPerWeek
+		if wait == 0 {
+			wait = minutesPerWeek
+		}
+		if best == -1 || wait < best {
+			best = wait
+		}
+	}
+	if best == -1 {
+		return time.Time{}, false
+	}
+	next := t.Truncate(time.Minute).Add(time.Duration(best) * time.Minute)
+	return next, true
+}
+
+// localTime converts t to the place's time zone, preferring a curator-set
+// IANA zone over the UTC offset Google reports.
+func (p *Place) localTime(t time.Time) time.Time {
+	if p.Timezone != "" {
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			return t.In(loc)
+		}
+	}
+	return t.In(time.FixedZone("", p.UTCOffset*60))
+}
+
+// markOpening sets Closed and, for closed places, an OpeningNote such as
+// "Closed now – opens at 18:00". Places without known opening hours are
+// assumed to be open.
+func (p *Place) markOpening(t time.Time) {
+	p.Closed = false
+	p.OpeningNote = ""
+	if p.OpeningHours == nil || len(p.OpeningHours.Periods) == 0 {
+		return
+	}
+	local := p.localTime(t)
+	if p.OpeningHours.IsOpenAt(local) {
+		return
+	}
+	p.Closed = true
+	p.OpeningNote = closedNote(local, p.OpeningHours)
+}
+
+func closedNote(local time.Time, hours *OpeningHours) string {
+	next, ok := hours.NextOpening(local)
+	if !ok {
+		return "Closed now"
+	}
+	if next.YearDay() == local.YearDay() {
+		return fmt.Sprintf("Closed now – opens at %s", next.Format("15:04"))
+	}
+	return fmt.Sprintf("Closed now – opens %s at %s", next.Format("Monday"), next.Format("15:04"))
+}
+
+// filterOpen drops places that are closed at t, or keeps them flagged as
+// closed when includeClosed is set.
+func filterOpen(places []Place, t time.Time, includeClosed bool) []Place {
+	var open []Place
+	for _, p := range places {
+		p.markOpening(t)
+		if p.Closed && !includeClosed {
+			continue
+		}
+		open = append(open, p)
+	}
+	return open
+}
+
+// refreshOpeningHours fetches opening hours for places whose hours are
+// missing or stale and that client can look up, returning the places it
+// updated. The details
+// fetched are kept, so sendPlaces needn't fetch them again.
+func refreshOpeningHours(client PlacesProvider, places []Place) []Place {
+	var stale []int
+	for i, p := range places {
+		if !canLookUp(client, p.ID) {
+			continue
+		}
+		if p.HoursUpdatedAt.IsZero() || time.Since(p.HoursUpdatedAt) >= openingHoursTTL {
+			stale = append(stale, i)
+		}
+	}
+	var refreshed []Place
+	for n, err := range getDetails(client, places, stale) {
+		p := &places[stale[n]]
+		if err != nil {
+			log.Printf("error fetching opening hours for %s: %s", p.ID, err)
+			continue
+		}
+		p.HoursUpdatedAt = time.Now()
+		refreshed = append(refreshed, *p)
+	}
+	return refreshed
+}
+
+// refreshCachedOpeningHours refreshes curated places' opening hours and
+// caches them for openingHoursTTL.
+func refreshCachedOpeningHours(repo PlaceRepository, client PlacesProvider, places []Place) {
+	for _, p := range refreshOpeningHours(client, places) {
+		if err := repo.SaveOpeningHours(p); err != nil {
+			log.Printf("error caching opening hours for %s: %s", p.ID, err)
+		}
+	}
+}
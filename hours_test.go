@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// dinnerHours opens Tuesday to Saturday 18:00 until 01:00 the next day.
+var dinnerHours = OpeningHours{
+	Periods: []OpeningPeriod{
+		{Open: DayTime{Day: 2, Time: "1800"}, Close: &DayTime{Day: 3, Time: "0100"}},
+		{Open: DayTime{Day: 3, Time: "1800"}, Close: &DayTime{Day: 4, Time: "0100"}},
+		{Open: DayTime{Day: 4, Time: "1800"}, Close: &DayTime{Day: 5, Time: "0100"}},
+		{Open: DayTime{Day: 5, Time: "1800"}, Close: &DayTime{Day: 6, Time: "0100"}},
+		{Open: DayTime{Day: 6, Time: "1800"}, Close: &DayTime{Day: 0, Time: "0100"}},
+	},
+}
+
+func TestIsOpenAt(t *testing.T) {
+	tests := []struct {
+		Time     string
+		Expected bool
+	}{
+		{Time: "2017-08-08 15:00", Expected: false}, // Tuesday afternoon
+		{Time: "2017-08-08 18:00", Expected: true},
+		{Time: "2017-08-09 00:30", Expected: true}, // Tuesday night
+		{Time: "2017-08-09 01:00", Expected: false},
+		{Time: "2017-08-13 00:30", Expected: true},  // Saturday night into Sunday
+		{Time: "2017-08-14 20:00", Expected: false}, // Monday
+	}
+
+	for _, test := range tests {
+		at, _ := time.Parse("2006-01-02 15:04", test.Time)
+		if got := dinnerHours.IsOpenAt(at); got != test.Expected {
+			t.Errorf("expected open %t at %s, got %t", test.Expected, test.Time, got)
+		}
+	}
+
+	// Open until midnight on Saturday, which Google gives as 2400.
+	lateHours := OpeningHours{Periods: []OpeningPeriod{{Open: DayTime{Day: 6, Time: "1800"}, Close: &DayTime{Day: 6, Time: "2400"}}}}
+	for at, expected := range map[string]bool{"2017-08-12 23:59": true, "2017-08-13 00:00": false, "2017-08-12 17:59": false} {
+		parsed, _ := time.Parse("2006-01-02 15:04", at)
+		if got := lateHours.IsOpenAt(parsed); got != expected {
+			t.Errorf("expected open %t at %s with hours until 2400, got %t", expected, at, got)
+		}
+	}
+	if _, err := (DayTime{Day: 1, Time: "2430"}).minuteOfWeek(); err == nil {
+		t.Errorf("expected 2430 to be an invalid time")
+	}
+
+	alwaysOpen := OpeningHours{Periods: []OpeningPeriod{{Open: DayTime{Day: 0, Time: "0000"}}}}
+	if !alwaysOpen.IsOpenAt(time.Now()) {
+		t.Errorf("expected period without close to always be open")
+	}
+}
+
+func TestMarkOpeningUsesPlaceTimezone(t *testing.T) {
+	// 15:00 UTC on a Tuesday is 18:00 in Moscow (UTC+3).
+	at := time.Date(2017, 8, 8, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Place    Place
+		Closed   bool
+		Expected string
+	}{
+		{
+			Place:    Place{OpeningHours: &dinnerHours},
+			Closed:   true,
+			Expected: "Closed now – opens at 18:00",
+		},
+		{
+			Place:  Place{OpeningHours: &dinnerHours, UTCOffset: 180},
+			Closed: false,
+		},
+		{
+			Place:  Place{OpeningHours: &dinnerHours, Timezone: "Europe/Moscow"},
+			Closed: false,
+		},
+		{
+			Place:  Place{},
+			Closed: false,
+		},
+	}
+
+	for _, test := range tests {
+		test.Place.markOpening(at)
+		if test.Place.Closed != test.Closed || test.Place.OpeningNote != test.Expected {
+			t.Errorf("expected closed %t with note %q, got %t with %q",
+				test.Closed, test.Expected, test.Place.Closed, test.Place.OpeningNote)
+		}
+	}
+}
+
+func TestClosedNoteOnAnotherDay(t *testing.T) {
+	monday := time.Date(2017, 8, 7, 15, 0, 0, 0, time.UTC)
+	place := Place{OpeningHours: &dinnerHours}
+	place.markOpening(monday)
+
+	expected := "Closed now – opens Tuesday at 18:00"
+	if place.OpeningNote != expected {
+		t.Errorf("expected note %q, got %q", expected, place.OpeningNote)
+	}
+}
+
+func TestFilterOpen(t *testing.T) {
+	at := time.Date(2017, 8, 8, 15, 0, 0, 0, time.UTC)
+	places := []Place{
+		{ID: "dinner", OpeningHours: &dinnerHours},
+		{ID: "unknown"},
+	}
+
+	open := filterOpen(places, at, false)
+	if len(open) != 1 || open[0].ID != "unknown" {
+		t.Errorf("expected only the place without hours, got %v", open)
+	}
+
+	flagged := filterOpen(places, at, true)
+	if len(flagged) != 2 || !flagged[0].Closed {
+		t.Errorf("expected closed place to be kept and flagged, got %v", flagged)
+	}
+}
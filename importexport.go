@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatCSV     = "csv"
+	formatGeoJSON = "geojson"
+)
+
+// csvHeader lists the export columns; types, cuisines and dietary are
+// separated by semicolons and opening_hours is JSON. Empty status,
+// curator_score and opening_hours mean the place has none.
+var csvHeader = []string{"googleid", "name", "lat", "lng", "status", "curator_score", "types", "cuisines", "dietary", "price_level", "note", "blurb", "recommended_dish",
+	"timezone", "utc_offset", "opening_hours"}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   GeoJSONPoint      `json:"geometry"`
+	Properties GeoJSONProperties `json:"properties"`
+}
+
+// GeoJSONPoint holds coordinates in GeoJSON order, longitude first.
+type GeoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+type GeoJSONProperties struct {
+	GoogleID        string        `json:"googleid"`
+	Name            string        `json:"name"`
+	Status          string        `json:"status,omitempty"`
+	CuratorScore    *float64      `json:"curator_score,omitempty"`
+	Types           []string      `json:"types,omitempty"`
+	Cuisines        []string      `json:"cuisines,omitempty"`
+	Dietary         []string      `json:"dietary,omitempty"`
+	PriceLevel      int           `json:"price_level,omitempty"`
+	Note            string        `json:"note,omitempty"`
+	Blurb           string        `json:"blurb,omitempty"`
+	RecommendedDish string        `json:"recommended_dish,omitempty"`
+	Timezone        string        `json:"timezone,omitempty"`
+	UTCOffset       int           `json:"utc_offset,omitempty"`
+	OpeningHours    *OpeningHours `json:"opening_hours,omitempty"`
+}
+
+// formatFromFilename guesses the import/export format from a file extension.
+func formatFromFilename(filename string) string {
+	lower := strings.ToLower(filename)
+	if strings.HasSuffix(lower, ".geojson") || strings.HasSuffix(lower, ".json") {
+		return formatGeoJSON
+	}
+	return formatCSV
+}
+
+func ReadPlaces(r io.Reader, format string) ([]Place, error) {
+	switch format {
+	case formatCSV:
+		return ReadPlacesCSV(r)
+	case formatGeoJSON:
+		return ReadPlacesGeoJSON(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func WritePlaces(w io.Writer, format string, places []Place) error {
+	switch format {
+	case formatCSV:
+		return WritePlacesCSV(w, places)
+	case formatGeoJSON:
+		return WritePlacesGeoJSON(w, places)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// ReadPlacesCSV reads places from a CSV file with a header row naming at least
+// the googleid column. Missing names and coordinates can be filled in by
+// preparePlaces.
+func ReadPlacesCSV(r io.Reader) ([]Place, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty csv")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := columns["googleid"]; !ok {
+		return nil, errors.New("csv has no googleid column")
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var places []Place
+	for n, record := range records[1:] {
+		line := n + 2
+		place := Place{
+			ID:              field(record, "googleid"),
+			Name:            field(record, "name"),
+			Status:          field(record, "status"),
+			Types:           splitTags(field(record, "types")),
+			Cuisines:        splitTags(field(record, "cuisines")),
+			Dietary:         splitTags(field(record, "dietary")),
+			Note:            field(record, "note"),
+			Blurb:           field(record, "blurb"),
+			RecommendedDish: field(record, "recommended_dish"),
+			Timezone:        field(record, "timezone"),
+		}
+		for _, f := range []struct {
+			name string
+			dest *int
+		}{
+			{"price_level", &place.PriceLevel},
+			{"utc_offset", &place.UTCOffset},
+		} {
+			v := field(record, f.name)
+			if v == "" {
+				continue
+			}
+			*f.dest, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line, f.name, v)
+			}
+		}
+		if v := field(record, "curator_score"); v != "" {
+			score, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid curator_score %q", line, v)
+			}
+			place.CuratorScore = &score
+		}
+		if v := field(record, "opening_hours"); v != "" {
+			place.OpeningHours = &OpeningHours{}
+			if err := json.Unmarshal([]byte(v), place.OpeningHours); err != nil {
+				return nil, fmt.Errorf("line %d: invalid opening_hours: %s", line, err)
+			}
+		}
+		for _, f := range []struct {
+			name string
+			dest *float64
+		}{
+			{"lat", &place.Location.Latitude},
+			{"lng", &place.Location.Longitude},
+		} {
+			v := field(record, f.name)
+			if v == "" {
+				continue
+			}
+			*f.dest, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line, f.name, v)
+			}
+		}
+		if place.ID == "" {
+			return nil, fmt.Errorf("line %d: missing googleid", line)
+		}
+		places = append(places, place)
+	}
+	return places, nil
+}
+
+func WritePlacesCSV(w io.Writer, places []Place) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, p := range places {
+		hours := ""
+		if p.OpeningHours != nil {
+			buf, err := json.Marshal(p.OpeningHours)
+			if err != nil {
+				return err
+			}
+			hours = string(buf)
+		}
+		err := writer.Write([]string{
+			p.ID,
+			p.Name,
+			strconv.FormatFloat(p.Location.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(p.Location.Longitude, 'f', -1, 64),
+			p.Status,
+			formatCuratorScore(p.CuratorScore),
+			strings.Join(p.Types, ";"),
+			strings.Join(p.Cuisines, ";"),
+			strings.Join(p.Dietary, ";"),
+			strconv.Itoa(p.PriceLevel),
+			p.Note,
+			p.Blurb,
+			p.RecommendedDish,
+			p.Timezone,
+			strconv.Itoa(p.UTCOffset),
+			hours,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func ReadPlacesGeoJSON(r io.Reader) ([]Place, error) {
+	var collection GeoJSONFeatureCollection
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, errors.New("geojson must be a FeatureCollection")
+	}
+
+	var places []Place
+	for i, feature := range collection.Features {
+		if feature.Properties.GoogleID == "" {
+			return nil, fmt.Errorf("feature %d: missing googleid", i)
+		}
+		place := Place{
+			ID:              feature.Properties.GoogleID,
+			Name:            feature.Properties.Name,
+			Status:          feature.Properties.Status,
+			CuratorScore:    feature.Properties.CuratorScore,
+			Types:           feature.Properties.Types,
+			Cuisines:        feature.Properties.Cuisines,
+			Dietary:         feature.Properties.Dietary,
+			PriceLevel:      feature.Properties.PriceLevel,
+			Note:            feature.Properties.Note,
+			Blurb:           feature.Properties.Blurb,
+			RecommendedDish: feature.Properties.RecommendedDish,
+			Timezone:        feature.Properties.Timezone,
+			UTCOffset:       feature.Properties.UTCOffset,
+			OpeningHours:    feature.Properties.OpeningHours,
+		}
+		if len(feature.Geometry.Coordinates) > 0 {
+			if feature.Geometry.Type != "Point" || len(feature.Geometry.Coordinates) != 2 {
+				return nil, fmt.Errorf("feature %d: geometry must be a Point", i)
+			}
+			place.Location = Location{
+				Longitude: feature.Geometry.Coordinates[0],
+				Latitude:  feature.Geometry.Coordinates[1],
+			}
+		}
+		places = append(places, place)
+	}
+	return places, nil
+}
+
+func WritePlacesGeoJSON(w io.Writer, places []Place) error {
+	collection := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+	for _, p := range places {
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: []float64{p.Location.Longitude, p.Location.Latitude},
+			},
+			Properties: GeoJSONProperties{
+				GoogleID:        p.ID,
+				Name:            p.Name,
+				Status:          p.Status,
+				CuratorScore:    p.CuratorScore,
+				Types:           p.Types,
+				Cuisines:        p.Cuisines,
+				Dietary:         p.Dietary,
+				PriceLevel:      p.PriceLevel,
+				Note:            p.Note,
+				Blurb:           p.Blurb,
+				RecommendedDish: p.RecommendedDish,
+				Timezone:        p.Timezone,
+				UTCOffset:       p.UTCOffset,
+				OpeningHours:    p.OpeningHours,
+			},
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(collection)
+}
+
+// formatCuratorScore writes a curator score, or nothing if there isn't one.
+func formatCuratorScore(score *float64) string {
+	if score == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*score, 'f', -1, 64)
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// preparePlaces fills in names and coordinates from Google when enrich is set
+// and validates every place before anything is written. Places without a
+// status are approved when created and keep theirs when updated.
+func preparePlaces(places []Place, client PlacesProvider, enrich bool) error {
+	for i := range places {
+		p := &places[i]
+		if enrich && (p.Name == "" || p.Location == Location{}) {
+			if err := p.GetDetails(client); err != nil {
+				return fmt.Errorf("%s: %s", p.ID, err)
+			}
+		}
+		if p.Name == "" {
+			return fmt.Errorf("%s: missing name", p.ID)
+		}
+		if (p.Location == Location{}) {
+			return fmt.Errorf("%s: missing coordinates", p.ID)
+		}
+		if _, err := NewLocation(p.Location.Latitude, p.Location.Longitude); err != nil {
+			return fmt.Errorf("%s: %s", p.ID, err)
+		}
+		if p.Status != "" && !validStatus(p.Status) {
+			return fmt.Errorf("%s: %s %q", p.ID, errInvalidStatus, p.Status)
+		}
+		if p.CuratorScore != nil && !validCuratorScore(*p.CuratorScore) {
+			return fmt.Errorf("%s: %s", p.ID, errInvalidCuratorScore)
+		}
+		if err := p.normaliseTags(); err != nil {
+			return fmt.Errorf("%s: %s", p.ID, err)
+		}
+	}
+	return nil
+}
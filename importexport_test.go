@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var importFixture = []Place{
+	{
+		ID:           "ChIJ3bKbZvmipBIR",
+		Name:         "Bar Marsella",
+		Status:       StatusApproved,
+		CuratorScore: floatPtr(4.5),
+		Location:     Location{Latitude: 41.3782, Longitude: 2.1718},
+		Types:        []string{"bar"},
+		Cuisines:     []string{"tapas", "bar"},
+		Dietary:      []string{"vegetarian"},
+		PriceLevel:   1,
+		Note:         "Absinthe, as it's been poured since 1820",
+		Blurb:        "Dusty bottles, peeling walls and the best nightcap in El Raval.",
+		OpeningHours: &dinnerHours,
+		UTCOffset:    60,
+		Timezone:     "Europe/Madrid",
+	},
+	{ID: "ChIJ5aE1", Name: "Bar Cañete", Status: StatusRejected, CuratorScore: floatPtr(0), Location: Location{Latitude: 41.3795, Longitude: 2.1731}},
+	{ID: "ChIJu2Dn1v2ipBIR", Name: "Cal Pep, Born", Status: StatusPending, Location: Location{Latitude: 41.3839, Longitude: 2.1826}},
+}
+
+func TestPlacesRoundTrip(t *testing.T) {
+	for _, format := range []string{formatCSV, formatGeoJSON} {
+		var buf bytes.Buffer
+		if err := WritePlaces(&buf, format, importFixture); err != nil {
+			t.Fatalf("unexpected error writing %s: %s", format, err)
+		}
+		got, err := ReadPlaces(&buf, format)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %s", format, err)
+		}
+		if !reflect.DeepEqual(importFixture, got) {
+			t.Errorf("expected %s round trip to give %v, got %v", format, importFixture, got)
+		}
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestReadPlacesCSVErrors(t *testing.T) {
+	tests := []string{
+		"name,lat,lng\nBar Marsella,41.3782,2.1718\n",
+		"googleid,name,lat,lng\nChIJ3bKbZvmipBIR,Bar Marsella,north,2.1718\n",
+		"googleid,name\n,Bar Marsella\n",
+		"googleid,name,opening_hours\nChIJ3bKbZvmipBIR,Bar Marsella,{\n",
+	}
+	for _, test := range tests {
+		if _, err := ReadPlacesCSV(strings.NewReader(test)); err == nil {
+			t.Errorf("expected error reading %q", test)
+		}
+	}
+}
+
+func TestPreparePlaces(t *testing.T) {
+	googleServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(GooglePlacesDetailsResponse{
+				Place: Place{
+					Name:     "Bar Marsella",
+					Geometry: Geometry{Location: Location{Latitude: 41.3782, Longitude: 2.1718}},
+				},
+			})
+		}),
+	)
+	defer googleServer.Close()
+	client := GooglePlacesClient{BaseURL: googleServer.URL}
+
+	places := []Place{{ID: "ChIJ3bKbZvmipBIR"}}
+	if err := preparePlaces(places, client, false); err == nil {
+		t.Errorf("expected error for place without name or coordinates")
+	}
+	if err := preparePlaces(places, client, true); err != nil {
+		t.Fatalf("unexpected error enriching places: %s", err)
+	}
+	if places[0].Name != "Bar Marsella" || places[0].Status != "" {
+		t.Errorf("expected enriched place without a status, got %+v", places[0])
+	}
+
+	invalid := [][]Place{
+		{{ID: "a", Name: "Nowhere", Location: Location{Latitude: 120, Longitude: 2}}},
+		{{ID: "a", Name: "Bar Marsella", Location: Location{Latitude: 41.3782, Longitude: 2.1718}, CuratorScore: floatPtr(7.5)}},
+		{{ID: "a", Name: "Bar Marsella", Location: Location{Latitude: 41.3782, Longitude: 2.1718}, Status: "maybe"}},
+	}
+	for _, places := range invalid {
+		if err := preparePlaces(places, client, false); err == nil {
+			t.Errorf("expected error for %+v", places[0])
+		}
+	}
+}
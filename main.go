@@ -1,7 +1,7 @@
 package main
 
 import (
-	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,27 +11,40 @@ import (
 	_ "github.com/lib/pq"
 )
 
-type Config struct {
-	APIBaseURL string
-}
-
-var DB *sql.DB
-
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		fmt.Println(err)
 	}
 
-	DB, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	config, args, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err == nil {
+		err = config.Validate(len(args) == 0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	http.HandleFunc("/messenger", MessengerRequestHandler)
+	app, err := NewApp(config)
+	if err != nil {
+		log.Fatal("could not open database: ", err)
+	}
+
+	if len(args) > 0 {
+		if err := app.runCommand(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	err = setPersistentMenu()
+	err = Migrate(app.DB)
 	if err != nil {
-		log.Printf("could not set persistent menu:", err)
+		log.Fatal("could not migrate database: ", err)
 	}
 
-	port := os.Getenv("PORT")
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+	go app.SendFeedbackRequests(feedbackPollInterval)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", app.Config.Port), app.Routes()))
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,10 +8,14 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
-const errNoLocation = "no location sent"
+const (
+	errNoLocation       = "no location sent"
+	errNoMessagingEvent = "no messaging event in webhook"
+)
 
 type MessengerResponse struct {
 	FBUser  FBUser    `json:"recipient"`
@@ -24,9 +27,17 @@ type FBUser struct {
 }
 
 type FBMessage struct {
-	Text        string         `json:"text,omitempty"`
-	Attachment  *FBAttachment  `json:"attachment,omitempty"`
-	Attachments []FBAttachment `json:"attachments,omitempty"`
+	Text         string         `json:"text,omitempty"`
+	Attachment   *FBAttachment  `json:"attachment,omitempty"`
+	Attachments  []FBAttachment `json:"attachments,omitempty"`
+	QuickReply   *FBQuickReply  `json:"quick_reply,omitempty"`
+	QuickReplies []FBQuickReply `json:"quick_replies,omitempty"`
+}
+
+type FBQuickReply struct {
+	ContentType string `json:"content_type,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Payload     string `json:"payload,omitempty"`
 }
 
 type FBAttachment struct {
@@ -47,8 +58,16 @@ type FBCoordinates struct {
 
 type FBPayloadElement struct {
 	Title         string          `json:"title,omitempty"`
+	Subtitle      string          `json:"subtitle,omitempty"`
 	ImageUrl      string          `json:"image_url,omitempty"`
 	DefaultAction FBDefaultAction `json:"default_action,omitempty"`
+	Buttons       []FBButton      `json:"buttons,omitempty"`
+}
+
+type FBButton struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Url   string `json:"url,omitempty"`
 }
 
 type FBDefaultAction struct {
@@ -58,78 +77,242 @@ type FBDefaultAction struct {
 
 type FBWebhookMsg struct {
 	Entry []struct {
-		Messaging []struct {
-			Sender  FBUser    `json:"sender"`
-			Message FBMessage `json:"message"`
-		} `json:"messaging"`
+		Messaging []FBMessagingEvent `json:"messaging"`
 	} `json:"entry"`
 }
 
-type ThreadSetting struct {
-	SettingType   string         `json:"setting_type"`
-	ThreadState   string         `json:"thread_state"`
-	CallToActions []CallToAction `json:"call_to_actions"`
+// FBMessagingEvent is a message from a user or, when they tap a button such
+// as one in the persistent menu, a postback.
+type FBMessagingEvent struct {
+	Sender   FBUser      `json:"sender"`
+	Message  FBMessage   `json:"message"`
+	Postback *FBPostback `json:"postback,omitempty"`
 }
 
-type CallToAction struct {
-	Type    string `json:"type"`
+type FBPostback struct {
 	Title   string `json:"title"`
 	Payload string `json:"payload"`
-	Url     string `json:"url"`
 }
 
-func MessengerRequestHandler(w http.ResponseWriter, r *http.Request) {
+func (app *App) MessengerRequestHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Message recieved")
 	if r.URL.Query().Get("hub.verify_token") != "" {
-		verifyToken(w, r)
+		app.verifyToken(w, r)
 		return
 	}
-	FBUserID, location, err := getUserDetails(r)
+	event, err := getUserMessage(r)
+	if err != nil {
+		log.Println("error getting FB User details: ", err)
+		return
+	}
+	FBUserID, message := event.Sender.ID, event.Message
+	if err := app.Users.Seen(FBUserID); err != nil {
+		log.Println("error recording user: ", err)
+	}
+	app.sendAction(FBUserID, senderActionMarkSeen)
+	if event.Postback != nil {
+		app.handlePostback(FBUserID, event.Postback.Payload)
+		return
+	}
+	if message.QuickReply != nil {
+		app.handleQuickReply(FBUserID, message.QuickReply.Payload)
+		return
+	}
+	location, err := getLocation(message)
 	if err != nil {
 		if err.Error() == errNoLocation {
-			sendText(FBUserID, "Send your location to get some delicious recommendations!")
+			if req, ok := parseSearchRequest(app.categories, message.Text); ok {
+				if req.Area != "" {
+					defer app.showTyping(FBUserID)()
+					app.searchArea(FBUserID, req)
+					return
+				}
+				app.pendingSearches.put(FBUserID, lastSearch{Request: req}, pendingSearchTTL)
+				app.sendLocationPrompt(FBUserID, fmt.Sprintf("Send me your location and I'll find somewhere %s.", req))
+				return
+			}
+			app.sendText(FBUserID, "Send your location to get some delicious recommendations!")
 			return
 		}
 		log.Println("error getting FB User details: ", err)
 		return
 	}
 
-	client := NewGooglePlacesClient(Config{})
-	googleRecommendations, err := location.GetPlacesFromGoogle(client)
+	pending, _ := app.pendingSearches.take(FBUserID)
+	defer app.showTyping(FBUserID)()
+	app.recommend(FBUserID, *location, pending.Request)
+}
+
+// recommend sends the best places near location that are open now, or at
+// req.Target if the user asked for somewhere open later.
+func (app *App) recommend(FBUserID string, location Location, req SearchRequest) {
+	client := app.Places
+	repo := NewPlaceRepository(app.DB)
+	now := time.Now()
+	user, err := app.Users.Get(FBUserID)
 	if err != nil {
-		log.Fatal("error accessing db: ", err)
-		return
+		log.Println("error getting user preferences: ", err)
+	}
+	req = user.searchRequest(req)
+	target := req.Target
+
+	search := NearbySearch{
+		OpenNow:  true,
+		Limit:    googleCandidates,
+		Type:     req.Type,
+		MaxPrice: req.MaxPrice,
+	}
+	if target != nil {
+		search.OpenNow = false
+		search.Limit = openLaterCandidates
+	}
+	var googleRecommendations []Place
+	if req.Query != "" {
+		googleRecommendations, err = client.TextSearch(TextQuery{
+			Query:    req.Query,
+			Location: &location,
+			Type:     req.Type,
+			OpenNow:  search.OpenNow,
+			MaxPrice: search.MaxPrice,
+			Limit:    search.Limit,
+		})
+	} else {
+		googleRecommendations, err = client.Nearby(location, search)
 	}
-	curatedRecommendations, err := GetPlacesFromDB(DB, *location)
+	if err != nil {
+		log.Println("error getting places from google: ", err)
+	}
+	if target != nil {
+		refreshOpeningHours(client, googleRecommendations)
+	}
+	curatedRecommendations, err := repo.Nearby(location, app.Config.Ranking, SearchOptions{
+		Dietary:       user.Dietary,
+		MaxPriceLevel: req.MaxPrice,
+		Type:          req.Type,
+	})
 	if err != nil {
 		fmt.Println(err)
 	}
+	if req.Query != "" {
+		curatedRecommendations = matchingQuery(curatedRecommendations, req.Query)
+	}
+	refreshCachedOpeningHours(repo, client, curatedRecommendations)
 
+	if target == nil {
+		curatedRecommendations = filterOpen(curatedRecommendations, now, app.Config.ClosedCuratedPlaces == closedCuratedFlag)
+	} else {
+		curatedRecommendations = filterOpenLater(curatedRecommendations, now, *target)
+		googleRecommendations = filterOpenLater(googleRecommendations, now, *target)
+	}
+
+	// Google doesn't know about dietary requirements, so only curated places
+	// are filtered by them.
+	recommendations := MergePlaces(user.preferCuisines(curatedRecommendations), googleRecommendations, placesLimit)
+	if len(recommendations) == 0 {
+		if target != nil {
+			app.sendText(FBUserID, fmt.Sprintf("Sorry, I couldn't find anywhere near you that's open %s.", target))
+			return
+		}
+		app.sendText(FBUserID, "Sorry, I couldn't find anywhere open near you.")
+		return
+	}
 	if len(curatedRecommendations) != 0 {
-		sendText(FBUserID, "I've been researching this area! I recommend...")
-		sendPlaces(curatedRecommendations, client, FBUserID)
+		app.sendText(FBUserID, "I've been researching this area! I recommend...")
+	} else {
+		app.sendText(FBUserID, "I don't have any recommendations in this area, but this is what turns up on Google...")
+	}
+	app.sendPlaces(recommendations, FBUserID)
+
+	last := lastSearch{
+		Request:       req,
+		Location:      location,
+		CheapestShown: cheapestPriceLevel(recommendations),
+	}
+	app.lastSearches.put(FBUserID, last, lastSearchTTL)
+	replies := categoryQuickReplies(app.categories, req.Type)
+	if _, ok := last.cheaperRequest(); ok {
+		replies = append([]FBQuickReply{{ContentType: "text", Title: "Cheaper options", Payload: cheaperPayload}}, replies...)
+	}
+	if len(replies) > 0 {
+		app.sendQuickReplies(FBUserID, "Something else?", replies...)
+	}
+}
+
+// searchArea finds the area the user named, like Soho in "best dumplings in
+// Soho", and recommends places there.
+func (app *App) searchArea(FBUserID string, req SearchRequest) {
+	areas, err := app.Places.TextSearch(app.areaQuery(FBUserID, req.Area))
+	if err != nil {
+		log.Println("error finding area: ", err)
+	}
+	if len(areas) == 0 {
+		app.pendingSearches.put(FBUserID, lastSearch{Request: req}, pendingSearchTTL)
+		app.sendLocationPrompt(FBUserID, fmt.Sprintf("I couldn't find %s. Send me your location and I'll look near you.", req.Area))
 		return
 	}
-	sendText(FBUserID, "I don't have any recommendations in this area, but this is what turns up on Google...")
-	sendPlaces(googleRecommendations, client, FBUserID)
+	app.sendText(FBUserID, fmt.Sprintf("Looking for somewhere %s...", req))
+	app.recommend(FBUserID, areas[0].Location, req)
 }
 
-func sendPlaces(places []Place, client GooglePlacesClient, FBUserID string) {
-	for _, place := range places {
-		err := place.GetDetails(client)
+// areaQuery looks for area near where the user last searched or, if we don't
+// know, in the configured SearchArea.
+func (app *App) areaQuery(FBUserID, area string) TextQuery {
+	q := TextQuery{Query: area, Limit: 1}
+	if last, ok := app.lastSearches.get(FBUserID); ok {
+		q.Location = &last.Location
+		q.Radius = areaSearchRadius
+	} else if app.Config.SearchArea != "" {
+		q.Query = area + ", " + app.Config.SearchArea
+	}
+	return q
+}
+
+// sendPlaces sends a card for each place, fetching its details first.
+// Curated places whose details can't be fetched are sent with what we know
+// about them; other places are left out.
+func (app *App) sendPlaces(places []Place, FBUserID string) {
+	var missing []int
+	for i, place := range places {
+		if !place.detailed && canLookUp(app.Places, place.ID) {
+			missing = append(missing, i)
+		}
+	}
+	failed := make(map[int]bool)
+	for n, err := range getDetails(app.Places, places, missing) {
 		if err != nil {
-			fmt.Println(err)
-			return
+			log.Printf("error getting details of %s: %s", places[missing[n]].ID, err)
+			failed[missing[n]] = true
+		}
+	}
+	for i, place := range places {
+		if failed[i] && !place.Curated {
+			continue
 		}
-		sendLocation(FBUserID, place)
-		sendText(FBUserID, fmt.Sprintf("%v\n%s", convertToStars(place.Rating), place.Website))
+		place.ImageUrl = placeImageUrl(app.Places, place)
+		app.sendLocation(FBUserID, place)
+		app.sendText(FBUserID, placeDetailsText(place))
 	}
 
 }
 
-func verifyToken(w http.ResponseWriter, r *http.Request) {
-	fbVerificationToken := os.Getenv("FB_VERIFICATION_TOKEN")
-	if r.FormValue("hub.verify_token") == fbVerificationToken {
+// placeDetailsText follows a place's card. Curated places lead with why we
+// like them and what to order.
+func placeDetailsText(place Place) string {
+	text := fmt.Sprintf("%v\n%s", convertToStars(place.Rating), place.Website)
+	if !place.Curated {
+		return text
+	}
+	if place.RecommendedDish != "" {
+		text = fmt.Sprintf("Order the %s.\n%s", place.RecommendedDish, text)
+	}
+	if place.Blurb != "" {
+		text = fmt.Sprintf("Why we like it: %s\n%s", place.Blurb, text)
+	}
+	return text
+}
+
+func (app *App) verifyToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("hub.verify_token") == app.Config.FBVerificationToken {
 		io.WriteString(w, r.FormValue("hub.challenge"))
 		return
 	} else {
@@ -139,59 +322,176 @@ func verifyToken(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getUserDetails(r *http.Request) (string, *Location, error) {
+func (app *App) handleQuickReply(FBUserID, payload string) {
+	if payload == cheaperPayload {
+		app.handleCheaperOptions(FBUserID)
+		return
+	}
+	if strings.HasPrefix(payload, placeTypePayload) {
+		app.handlePlaceType(FBUserID, strings.TrimPrefix(payload, placeTypePayload))
+		return
+	}
+	if strings.HasPrefix(payload, dietaryPayload) {
+		app.handleDietaryReply(FBUserID, strings.TrimPrefix(payload, dietaryPayload))
+		return
+	}
+	if payload == preferencesPayload {
+		app.handlePreferences(FBUserID)
+		return
+	}
+	if strings.HasPrefix(payload, preferencePayload) {
+		app.handlePreferenceReply(FBUserID, strings.TrimPrefix(payload, preferencePayload))
+		return
+	}
+	if strings.HasPrefix(payload, budgetPayload) {
+		app.handleBudgetReply(FBUserID, strings.TrimPrefix(payload, budgetPayload))
+		return
+	}
+	if strings.HasPrefix(payload, cuisinePayload) {
+		app.handleCuisineReply(FBUserID, strings.TrimPrefix(payload, cuisinePayload))
+		return
+	}
+	feedback, err := parseFeedbackPayload(FBUserID, payload)
+	if err != nil {
+		log.Println("error parsing quick reply: ", err)
+		return
+	}
+	err = SaveFeedback(app.DB, feedback)
+	if err != nil {
+		log.Println("error saving feedback: ", err)
+		return
+	}
+	app.sendText(FBUserID, "Thanks for letting me know!")
+}
+
+// handlePostback handles taps on the Get Started button, the persistent menu
+// and ice breakers, whose payloads are otherwise the same as quick replies'.
+func (app *App) handlePostback(FBUserID, payload string) {
+	if payload == getStartedPayload {
+		app.handleGetStarted(FBUserID)
+		return
+	}
+	app.handleQuickReply(FBUserID, payload)
+}
+
+func getUserMessage(r *http.Request) (FBMessagingEvent, error) {
 	var req FBWebhookMsg
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return "", nil, err
+		return FBMessagingEvent{}, err
 	}
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return "", nil, err
+		return FBMessagingEvent{}, err
 	}
+	if len(req.Entry) == 0 || len(req.Entry[0].Messaging) == 0 {
+		return FBMessagingEvent{}, errors.New(errNoMessagingEvent)
+	}
+	return req.Entry[0].Messaging[0], nil
+}
 
-	userID := req.Entry[0].Messaging[0].Sender.ID
-
-	message := req.Entry[0].Messaging[0].Message
+func getLocation(message FBMessage) (*Location, error) {
 	if message.Attachments == nil {
-		return userID, nil, errors.New(errNoLocation)
+		return nil, errors.New(errNoLocation)
 	}
 
 	if message.Attachments[0].Type != "location" {
-		return userID, nil, errors.New(errNoLocation)
+		return nil, errors.New(errNoLocation)
 	}
 
 	lat := message.Attachments[0].Payload.Coordinates.Lat
 	long := message.Attachments[0].Payload.Coordinates.Long
 
-	location, err := NewLocation(lat, long)
-	return userID, location, err
+	return NewLocation(lat, long)
 }
 
-func sendText(user, text string) {
+func (app *App) sendText(user, text string) {
 	message := FBMessage{
 		Text: text,
 	}
-	err := sendToMessenger(user, message)
+	err := app.Messenger.Send(user, message)
 	if err != nil {
-		log.Println("error sending text response to messenger: ", err)
+		app.sendFailed(user, "text response", err)
 	}
 	return
 }
 
-func sendLocation(user string, p Place) {
+func (app *App) sendQuickReplies(user, text string, replies ...FBQuickReply) {
+	message := FBMessage{
+		Text:         text,
+		QuickReplies: replies,
+	}
+	err := app.Messenger.Send(user, message)
+	if err != nil {
+		app.sendFailed(user, "quick replies", err)
+	}
+}
+
+func (app *App) sendAction(user, action string) {
+	err := app.Messenger.SenderAction(user, action)
+	if err != nil {
+		app.sendFailed(user, action, err)
+	}
+}
+
+// showTyping turns the typing indicator on while a search runs, returning a
+// function to turn it off once the reply is sent.
+func (app *App) showTyping(user string) func() {
+	app.sendAction(user, senderActionTypingOn)
+	return func() {
+		app.sendAction(user, senderActionTypingOff)
+	}
+}
+
+// sendFailed logs an error sending what to the user. Users who have blocked
+// the page are marked blocked, so we stop messaging them unprompted.
+func (app *App) sendFailed(user, what string, err error) {
+	if !isUserBlocked(err) {
+		log.Printf("error sending %s to messenger: %s", what, err)
+		return
+	}
+	log.Printf("user %s has blocked the page", user)
+	if err := app.Users.SetBlocked(user, true); err != nil {
+		log.Println("error marking user blocked: ", err)
+	}
+}
+
+// sendLocationPrompt asks for the user's location with Messenger's "Send
+// Location" quick reply.
+func (app *App) sendLocationPrompt(user, text string) {
+	message := FBMessage{
+		Text: text,
+		QuickReplies: []FBQuickReply{
+			{ContentType: "location"},
+		},
+	}
+	err := app.Messenger.Send(user, message)
+	if err != nil {
+		app.sendFailed(user, "location prompt", err)
+	}
+}
+
+func (app *App) sendLocation(user string, p Place) {
 	attachment := FBAttachment{
 		Type: "template",
 		Payload: FBPayload{
 			TemplateType: "generic",
 			Elements: []FBPayloadElement{
 				{
-					Title: p.Name,
+					Title:    p.Name,
+					Subtitle: p.Subtitle(),
 					DefaultAction: FBDefaultAction{
 						Type: "web_url",
 						Url:  p.LinkMapUrl(),
 					},
-					ImageUrl: p.StaticMapUrl(),
+					ImageUrl: p.ImageUrl,
+					Buttons: []FBButton{
+						{
+							Type:  "web_url",
+							Title: "Directions",
+							Url:   p.DirectionsUrl(app.Config.AppURL, app.Config.LinkSecret, user),
+						},
+					},
 				},
 			},
 		},
@@ -200,67 +500,13 @@ func sendLocation(user string, p Place) {
 	message := FBMessage{
 		Attachment: &attachment,
 	}
-	err := sendToMessenger(user, message)
+	err := app.Messenger.Send(user, message)
 	if err != nil {
-		log.Println("error sending location response to messenger: ", err)
+		app.sendFailed(user, "location response", err)
 	}
 	return
 }
 
-func sendToMessenger(user string, message FBMessage) error {
-	payload := MessengerResponse{
-		FBUser: FBUser{
-			ID: user,
-		},
-		Message: message,
-	}
-	buf, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	url := fmt.Sprintf("https://graph.facebook.com/v2.8/me/messages?access_token=%s", os.Getenv("FB_PAGE_TOKEN"))
-	return post(url, buf)
-}
-
-func setPersistentMenu() error {
-	threadSetting := ThreadSetting{
-		SettingType: "call_to_actions",
-		ThreadState: "existing_thread",
-		CallToActions: []CallToAction{
-			CallToAction{
-				Type:  "web_url",
-				Title: "Make a recommendation",
-				Url:   "https://nicolaa.typeform.com/to/noVPUi",
-			},
-		},
-	}
-
-	url := fmt.Sprintf("https://graph.facebook.com/%s/thread_settings?access_token=%s", os.Getenv("FB_PAGE_ID"), os.Getenv("FB_PAGE_TOKEN"))
-
-	payload, err := json.Marshal(threadSetting)
-	if err != nil {
-		return err
-	}
-	return post(url, payload)
-}
-
-func post(url string, payload []byte) error {
-	resp, err := http.Post(url, "application/json; charset=utf-8", bytes.NewReader(payload))
-	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		return errors.New(fmt.Sprintf("error response from Messenger: ", string(body)))
-	}
-	return nil
-}
-
 func convertToStars(rating float64) string {
 	var stars string
 	for i := 0; i < int(rating); i++ {
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	graphAPIBaseURL  = "https://graph.facebook.com/v2.8"
+	messengerTimeout = 10 * time.Second
+	maxSendAttempts  = 3
+	// retryBackoff doubles after each failed attempt.
+	retryBackoff = 500 * time.Millisecond
+
+	errUserBlocked = "user has blocked the page"
+
+	senderActionMarkSeen  = "mark_seen"
+	senderActionTypingOn  = "typing_on"
+	senderActionTypingOff = "typing_off"
+)
+
+// Graph API error codes we act on.
+const (
+	graphErrorService        = 2
+	graphErrorAppRateLimit   = 4
+	graphErrorUserRateLimit  = 17
+	graphErrorPageRateLimit  = 32
+	graphErrorCallsRateLimit = 613
+	graphErrorUnavailable    = 551
+	graphErrorPermission     = 200
+	graphSubcodeUserBlocked  = 1545041
+)
+
+// GraphError is an error object returned by the Graph API.
+type GraphError struct {
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	Code      int    `json:"code"`
+	Subcode   int    `json:"error_subcode"`
+	FBTraceID string `json:"fbtrace_id"`
+	// StatusCode is the HTTP status of the response.
+	StatusCode int `json:"-"`
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("messenger error %d (subcode %d): %s [fbtrace_id %s]", e.Code, e.Subcode, e.Message, e.FBTraceID)
+}
+
+func (e *GraphError) RateLimited() bool {
+	switch e.Code {
+	case graphErrorAppRateLimit, graphErrorUserRateLimit, graphErrorPageRateLimit, graphErrorCallsRateLimit:
+		return true
+	}
+	return false
+}
+
+// UserBlocked reports whether the user has blocked the page or otherwise
+// can't be messaged.
+func (e *GraphError) UserBlocked() bool {
+	return e.Code == graphErrorUnavailable || (e.Code == graphErrorPermission && e.Subcode == graphSubcodeUserBlocked)
+}
+
+// UserBlockedError is returned for users who have blocked the page.
+type UserBlockedError struct {
+	User string
+}
+
+func (e UserBlockedError) Error() string {
+	return fmt.Sprintf("%s: %s", e.User, errUserBlocked)
+}
+
+func isUserBlocked(err error) bool {
+	_, ok := err.(UserBlockedError)
+	return ok
+}
+
+type senderActionRequest struct {
+	FBUser       FBUser `json:"recipient"`
+	SenderAction string `json:"sender_action"`
+}
+
+type graphErrorResponse struct {
+	Error *GraphError `json:"error"`
+}
+
+// MessengerClient talks to the Messenger Platform for one page. Sends are
+// rate limited and retried when they can't have been delivered.
+type MessengerClient struct {
+	BaseURL   string
+	PageToken string
+	Client    *http.Client
+
+	limiter *tokenBucket
+	sleep   func(time.Duration)
+}
+
+func NewMessengerClient(c Config) *MessengerClient {
+	return &MessengerClient{
+		BaseURL:   graphAPIBaseURL,
+		PageToken: c.FBPageToken,
+		Client:    &http.Client{Timeout: messengerTimeout},
+		limiter:   newTokenBucket(c.MessengerRateLimit),
+		sleep:     time.Sleep,
+	}
+}
+
+func (m *MessengerClient) Send(user string, message FBMessage) error {
+	return m.sendTo(user, MessengerResponse{
+		FBUser:  FBUser{ID: user},
+		Message: message,
+	})
+}
+
+// SenderAction marks the user's last message seen or turns the typing
+// indicator on or off.
+func (m *MessengerClient) SenderAction(user, action string) error {
+	return m.sendTo(user, senderActionRequest{
+		FBUser:       FBUser{ID: user},
+		SenderAction: action,
+	})
+}
+
+// UserLocale looks up the user's locale, e.g. en_GB, with the User Profile
+// API.
+func (m *MessengerClient) UserLocale(user string) (string, error) {
+	var profile struct {
+		Locale string `json:"locale"`
+	}
+	err := m.do("GET", "/"+url.PathEscape(user)+"?fields=locale", nil, &profile)
+	return profile.Locale, err
+}
+
+func (m *MessengerClient) sendTo(user string, payload interface{}) error {
+	err := m.post("/me/messages", payload)
+	if graphErr, ok := err.(*GraphError); ok && graphErr.UserBlocked() {
+		return UserBlockedError{User: user}
+	}
+	return err
+}
+
+// post sends payload to the Graph API path, retrying with exponential backoff
+// when it can't have been delivered.
+func (m *MessengerClient) post(path string, payload interface{}) error {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		if m.limiter != nil {
+			m.limiter.wait()
+		}
+		err = m.do("POST", path, buf, nil)
+		if attempt == maxSendAttempts || !retryable(err) {
+			return err
+		}
+		log.Printf("retrying messenger request after error: %s", err)
+		m.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// do makes one request, decoding a successful response into v if it's not nil.
+func (m *MessengerClient) do(method, path string, body []byte, v interface{}) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	u := m.BaseURL + path + separator + url.Values{"access_token": {m.PageToken}}.Encode()
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		// Drop the URL, which holds the page token.
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return graphError(resp)
+	}
+	if v == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func graphError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	var decoded graphErrorResponse
+	if json.Unmarshal(body, &decoded) != nil || decoded.Error == nil {
+		return &GraphError{Message: fmt.Sprintf("%s: %s", resp.Status, body), StatusCode: resp.StatusCode}
+	}
+	decoded.Error.StatusCode = resp.StatusCode
+	return decoded.Error
+}
+
+// retryable reports whether a send failed without being delivered, so can be
+// retried without the user getting it twice: we couldn't connect, or
+// Messenger rate limited us. Other failures, such as timeouts and server
+// errors, may have happened after the message was sent.
+func retryable(err error) bool {
+	if graphErr, ok := err.(*GraphError); ok {
+		return graphErr.RateLimited()
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+// tokenBucket allows bursts of up to capacity requests and rate requests a
+// second after that.
+type tokenBucket struct {
+	sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity: rate,
+		rate:     rate,
+		tokens:   rate,
+		last:     time.Now(),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// wait blocks until a request is allowed. The token is taken straight away,
+// leaving the bucket in debt if need be, so other requests queue behind it
+// while it sleeps without holding the lock.
+func (b *tokenBucket) wait() {
+	b.Lock()
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.Unlock()
+	if delay > 0 {
+		b.sleep(delay)
+	}
+}
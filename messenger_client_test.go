@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testMessengerClient(url string) *MessengerClient {
+	client := NewMessengerClient(Config{FBPageToken: "token"})
+	client.BaseURL = url
+	client.limiter = nil
+	client.sleep = func(time.Duration) {}
+	return client
+}
+
+func TestMessengerClientSend(t *testing.T) {
+	var got MessengerResponse
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/me/messages" || r.URL.Query().Get("access_token") != "token" {
+				t.Errorf("unexpected request %s", r.URL)
+			}
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"recipient_id": "1234", "message_id": "mid.1"}`))
+		}),
+	)
+	defer server.Close()
+
+	err := testMessengerClient(server.URL).Send("1234", FBMessage{Text: "hello"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.FBUser.ID != "1234" || got.Message.Text != "hello" {
+		t.Errorf("expected hello to 1234, got %+v", got)
+	}
+}
+
+func TestMessengerClientSenderAction(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"recipient_id": "1234"}`))
+		}),
+	)
+	defer server.Close()
+
+	if err := testMessengerClient(server.URL).SenderAction("1234", senderActionTypingOn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"recipient":     map[string]interface{}{"id": "1234"},
+		"sender_action": "typing_on",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMessengerClientUserLocale(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/1234" || r.URL.Query().Get("fields") != "locale" {
+				t.Errorf("unexpected request %s", r.URL)
+			}
+			w.Write([]byte(`{"locale": "en_GB", "id": "1234"}`))
+		}),
+	)
+	defer server.Close()
+
+	locale, err := testMessengerClient(server.URL).UserLocale("1234")
+	if err != nil || locale != "en_GB" {
+		t.Errorf("expected en_GB, got %q, %v", locale, err)
+	}
+}
+
+func TestMessengerClientRetries(t *testing.T) {
+	tests := []struct {
+		Status   int
+		Body     string
+		Attempts int
+		Code     int
+	}{
+		{
+			Status:   http.StatusInternalServerError,
+			Body:     `{"error": {"message": "An unexpected error has occurred.", "code": 2, "fbtrace_id": "AbC"}}`,
+			Attempts: 1,
+			Code:     graphErrorService,
+		},
+		{
+			Status:   http.StatusBadRequest,
+			Body:     `{"error": {"message": "(#613) Calls to this api have exceeded the rate limit.", "code": 613, "fbtrace_id": "AbC"}}`,
+			Attempts: maxSendAttempts,
+			Code:     graphErrorCallsRateLimit,
+		},
+		{
+			Status:   http.StatusBadRequest,
+			Body:     `{"error": {"message": "(#100) The parameter recipient is required", "code": 100, "fbtrace_id": "AbC"}}`,
+			Attempts: 1,
+			Code:     100,
+		},
+	}
+
+	for _, test := range tests {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(test.Status)
+				w.Write([]byte(test.Body))
+			}),
+		)
+		err := testMessengerClient(server.URL).Send("1234", FBMessage{Text: "hello"})
+		server.Close()
+
+		graphErr, ok := err.(*GraphError)
+		if !ok || graphErr.Code != test.Code || graphErr.FBTraceID != "AbC" {
+			t.Errorf("expected graph error %d, got %v", test.Code, err)
+		}
+		if attempts != test.Attempts {
+			t.Errorf("expected %d attempts for error %d, got %d", test.Attempts, test.Code, attempts)
+		}
+	}
+}
+
+func TestMessengerClientRetrySucceeds(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": {"message": "(#4) Application request limit reached", "code": 4, "fbtrace_id": "AbC"}}`))
+				return
+			}
+			w.Write([]byte(`{}`))
+		}),
+	)
+	defer server.Close()
+
+	if err := testMessengerClient(server.URL).Send("1234", FBMessage{Text: "hello"}); err != nil {
+		t.Errorf("expected the retry to succeed, got %s", err)
+	}
+}
+
+func TestMessengerClientRetriesConnectionFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	client := testMessengerClient(server.URL)
+	server.Close()
+	sleeps := 0
+	client.sleep = func(time.Duration) { sleeps++ }
+
+	if err := client.Send("1234", FBMessage{Text: "hello"}); err == nil {
+		t.Fatalf("expected an error sending to a closed server")
+	}
+	if sleeps != maxSendAttempts-1 {
+		t.Errorf("expected %d retries, got %d", maxSendAttempts-1, sleeps)
+	}
+}
+
+func TestMessengerClientUserBlocked(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "(#551) This person isn't available right now.", "code": 551, "error_subcode": 1545041, "fbtrace_id": "AbC"}}`))
+		}),
+	)
+	defer server.Close()
+	client := testMessengerClient(server.URL)
+
+	if err := client.Send("1234", FBMessage{Text: "hello"}); !isUserBlocked(err) {
+		t.Errorf("expected user blocked error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a blocked send not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	var slept time.Duration
+	bucket := newTokenBucket(2)
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+	bucket.sleep = func(d time.Duration) { slept += d }
+
+	bucket.wait()
+	bucket.wait()
+	if slept != 0 {
+		t.Errorf("expected a burst of two without waiting, slept %s", slept)
+	}
+	bucket.wait()
+	if slept != 500*time.Millisecond {
+		t.Errorf("expected to wait for the next token, slept %s", slept)
+	}
+	now = now.Add(time.Second)
+	bucket.wait()
+	if slept != 500*time.Millisecond {
+		t.Errorf("expected tokens to refill, slept %s", slept)
+	}
+}
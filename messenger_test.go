@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
 
 func TestFormatRating(t *testing.T) {
 	tests := []struct {
@@ -28,3 +34,140 @@ func TestFormatRating(t *testing.T) {
 		}
 	}
 }
+
+func TestPlaceDetailsText(t *testing.T) {
+	tests := []struct {
+		Place    Place
+		Expected string
+	}{
+		{
+			Place:    Place{Rating: 4, Website: "www.example.com", Blurb: "Ignored for Google places"},
+			Expected: "★★★★\nwww.example.com",
+		},
+		{
+			Place:    Place{Curated: true, Rating: 4, Website: "www.example.com"},
+			Expected: "★★★★\nwww.example.com",
+		},
+		{
+			Place: Place{
+				Curated:         true,
+				Rating:          4.5,
+				Website:         "www.example.com",
+				Blurb:           "Dusty bottles and the best nightcap in town.",
+				RecommendedDish: "absinthe",
+			},
+			Expected: "Why we like it: Dusty bottles and the best nightcap in town.\nOrder the absinthe.\n★★★★ ½\nwww.example.com",
+		},
+	}
+
+	for _, test := range tests {
+		if got := placeDetailsText(test.Place); got != test.Expected {
+			t.Errorf("expected %q, got %q", test.Expected, got)
+		}
+	}
+}
+
+// searchRecorder records the searches made of it.
+type searchRecorder struct {
+	stubProvider
+	Searches *[]NearbySearch
+}
+
+func (s searchRecorder) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	*s.Searches = append(*s.Searches, search)
+	return s.stubProvider.Nearby(l, search)
+}
+
+func TestRecommendFillsUpWithGooglePlaces(t *testing.T) {
+	db, _ := newFakeDB(t, fakeRows{}, fakeRows{Values: [][]driver.Value{curatedRow("g1", "Bar Marsella", int64(0))}})
+	var searches []NearbySearch
+	var google []Place
+	for _, id := range []string{"g1", "g2", "g3", "g4", "g5", "g6"} {
+		google = append(google, Place{ID: id})
+	}
+	sender := &fakeSender{}
+	app := newApp(Config{}, db, searchRecorder{stubProvider{Places: google}, &searches}, sender)
+
+	app.recommend("123", Location{Latitude: 41.38, Longitude: 2.17}, SearchRequest{})
+
+	if len(searches) != 1 || searches[0].Limit <= placesLimit {
+		t.Fatalf("expected one search for more than %d places, got %+v", placesLimit, searches)
+	}
+	var cards []string
+	for _, sent := range sender.Sent {
+		if sent.Message.Attachment != nil {
+			cards = append(cards, sent.Message.Attachment.Payload.Elements[0].DefaultAction.Url)
+		}
+	}
+	if len(cards) != placesLimit {
+		t.Errorf("expected %d places, got %v", placesLimit, cards)
+	}
+}
+
+func TestSendPlacesWithoutDetails(t *testing.T) {
+	sender := &fakeSender{}
+	app := newApp(Config{}, nil, stubProvider{Err: errors.New("over quota")}, sender)
+	places := []Place{
+		{ID: "ChIJ3bKbZvmipBIR", Name: "Bar Marsella", Curated: true, Location: Location{Latitude: 41.3782, Longitude: 2.1718}},
+		{ID: "ChIJu2Dn1v2ipBIR", Name: "Cal Pep"},
+	}
+
+	app.sendPlaces(places, "123")
+
+	var titles []string
+	for _, sent := range sender.Sent {
+		if sent.Message.Attachment != nil {
+			titles = append(titles, sent.Message.Attachment.Payload.Elements[0].Title)
+		}
+	}
+	if expected := []string{"Bar Marsella"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected cards for %v, got %v", expected, titles)
+	}
+}
+
+// detailsCounter counts the details requested of it.
+type detailsCounter struct {
+	stubProvider
+	sync.Mutex
+	Calls map[string]int
+}
+
+func (d *detailsCounter) Details(id string) (Place, error) {
+	d.Lock()
+	d.Calls[id]++
+	d.Unlock()
+	return d.stubProvider.Details(id)
+}
+
+func TestRecommendFetchesDetailsOnce(t *testing.T) {
+	for _, req := range []SearchRequest{{}, {Target: &TargetTime{Hour: 20}}} {
+		db, _ := newFakeDB(t, fakeRows{}, fakeRows{Values: [][]driver.Value{
+			curatedRow("c1", "Bar Marsella", int64(0)),
+			curatedRow("c2", "Bar del Pla", int64(0)),
+		}})
+		provider := &detailsCounter{stubProvider: stubProvider{Places: []Place{{ID: "g1"}, {ID: "g2"}}}, Calls: make(map[string]int)}
+		app := newApp(Config{}, db, provider, &fakeSender{})
+		app.recommend("123", Location{Latitude: 41.38, Longitude: 2.17}, req)
+
+		for _, id := range []string{"c1", "c2", "g1"} {
+			if provider.Calls[id] != 1 {
+				t.Errorf("expected details of %s to be fetched once for %+v, got %v", id, req, provider.Calls)
+			}
+		}
+	}
+}
+
+func TestAreaQuery(t *testing.T) {
+	app := newApp(Config{SearchArea: "London"}, nil, stubProvider{}, &fakeSender{})
+
+	if q := app.areaQuery("123", "Soho"); q.Query != "Soho, London" || q.Location != nil {
+		t.Errorf("expected Soho to be looked for in London, got %+v", q)
+	}
+
+	last := Location{Latitude: 40.72, Longitude: -74}
+	app.lastSearches.put("123", lastSearch{Location: last}, lastSearchTTL)
+	q := app.areaQuery("123", "Soho")
+	if q.Query != "Soho" || q.Location == nil || *q.Location != last || q.Radius != areaSearchRadius {
+		t.Errorf("expected Soho to be looked for near the last search, got %+v", q)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrations are applied in order and recorded in schema_migrations. Once a
+// migration has shipped, add a new one rather than editing it.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_extensions",
+		Up: `CREATE EXTENSION IF NOT EXISTS cube;
+			CREATE EXTENSION IF NOT EXISTS earthdistance;`,
+		Down: `DROP EXTENSION IF EXISTS earthdistance;
+			DROP EXTENSION IF EXISTS cube;`,
+	},
+	{
+		Version: 2,
+		Name:    "create_places",
+		Up: `CREATE TABLE IF NOT EXISTS places (
+				googleid text PRIMARY KEY,
+				name text NOT NULL,
+				location point NOT NULL
+			);
+			ALTER TABLE places ADD COLUMN IF NOT EXISTS curator_score numeric(2, 1);
+			ALTER TABLE places ADD COLUMN IF NOT EXISTS recommended_at timestamptz NOT NULL DEFAULT now();
+			CREATE UNIQUE INDEX IF NOT EXISTS places_googleid_idx ON places (googleid);
+			CREATE INDEX IF NOT EXISTS places_location_idx ON places USING gist (location);`,
+		Down: `DROP TABLE IF EXISTS places;`,
+	},
+	{
+		Version: 3,
+		Name:    "create_feedback",
+		Up: `CREATE TABLE IF NOT EXISTS feedback (
+				googleid text NOT NULL,
+				psid text NOT NULL,
+				score smallint NOT NULL CHECK (score IN (-1, 1)),
+				created_at timestamptz NOT NULL DEFAULT now(),
+				PRIMARY KEY (googleid, psid)
+			);`,
+		Down: `DROP TABLE IF EXISTS feedback;`,
+	},
+	{
+		Version: 4,
+		Name:    "create_place_submissions",
+		Up: `CREATE TABLE place_submissions (
+				googleid text PRIMARY KEY,
+				name text NOT NULL,
+				location point NOT NULL,
+				submitted_at timestamptz NOT NULL DEFAULT now()
+			);`,
+		Down: `DROP TABLE place_submissions;`,
+	},
+	{
+		Version: 5,
+		Name:    "add_moderation",
+		Up: `ALTER TABLE places ADD COLUMN status text NOT NULL DEFAULT 'approved'
+				CHECK (status IN ('pending', 'approved', 'rejected'));
+			CREATE INDEX places_status_idx ON places (status);
+			INSERT INTO places (googleid, name, location, recommended_at, status)
+				SELECT googleid, name, location, submitted_at, 'pending' FROM place_submissions
+				ON CONFLICT (googleid) DO NOTHING;
+			DROP TABLE place_submissions;
+			CREATE TABLE place_audit (
+				id serial PRIMARY KEY,
+				googleid text NOT NULL,
+				actor text NOT NULL,
+				action text NOT NULL,
+				changes jsonb NOT NULL DEFAULT '{}',
+				created_at timestamptz NOT NULL DEFAULT now()
+			);
+			CREATE INDEX place_audit_googleid_idx ON place_audit (googleid);`,
+		Down: `DROP TABLE place_audit;
+			CREATE TABLE place_submissions (
+				googleid text PRIMARY KEY,
+				name text NOT NULL,
+				location point NOT NULL,
+				submitted_at timestamptz NOT NULL DEFAULT now()
+			);
+			INSERT INTO place_submissions SELECT googleid, name, location, recommended_at FROM places WHERE status = 'pending';
+			DELETE FROM places WHERE status <> 'approved';
+			ALTER TABLE places DROP COLUMN status;`,
+	},
+	{
+		Version: 6,
+		Name:    "add_places_tags",
+		Up: `ALTER TABLE places
+				ADD COLUMN cuisines text[] NOT NULL DEFAULT '{}',
+				ADD COLUMN dietary text[] NOT NULL DEFAULT '{}',
+				ADD COLUMN price_level smallint CHECK (price_level BETWEEN 1 AND 4),
+				ADD COLUMN note text NOT NULL DEFAULT '';
+			CREATE INDEX places_cuisines_idx ON places USING gin (cuisines);
+			CREATE INDEX places_dietary_idx ON places USING gin (dietary);`,
+		Down: `ALTER TABLE places
+				DROP COLUMN cuisines,
+				DROP COLUMN dietary,
+				DROP COLUMN price_level,
+				DROP COLUMN note;`,
+	},
+	{
+		Version: 7,
+		Name:    "add_places_blurb",
+		Up: `ALTER TABLE places
+				ADD COLUMN blurb text NOT NULL DEFAULT '',
+				ADD COLUMN recommended_dish text NOT NULL DEFAULT '';`,
+		Down: `ALTER TABLE places
+				DROP COLUMN blurb,
+				DROP COLUMN recommended_dish;`,
+	},
+	{
+		Version: 8,
+		Name:    "add_places_opening_hours",
+		Up: `ALTER TABLE places
+				ADD COLUMN opening_hours jsonb,
+				ADD COLUMN utc_offset integer,
+				ADD COLUMN timezone text NOT NULL DEFAULT '',
+				ADD COLUMN hours_updated_at timestamptz;`,
+		Down: `ALTER TABLE places
+				DROP COLUMN opening_hours,
+				DROP COLUMN utc_offset,
+				DROP COLUMN timezone,
+				DROP COLUMN hours_updated_at;`,
+	},
+	{
+		Version: 9,
+		Name:    "add_places_types",
+		Up: `ALTER TABLE places ADD COLUMN types text[] NOT NULL DEFAULT '{restaurant}';
+			CREATE INDEX places_types_idx ON places USING gin (types);`,
+		Down: `ALTER TABLE places DROP COLUMN types;`,
+	},
+	{
+		Version: 10,
+		Name:    "create_osm_places",
+		Up: `CREATE TABLE osm_places (
+				id text PRIMARY KEY,
+				name text NOT NULL,
+				location point NOT NULL,
+				types text[] NOT NULL DEFAULT '{}',
+				cuisines text[] NOT NULL DEFAULT '{}',
+				website text NOT NULL DEFAULT '',
+				opening_hours jsonb,
+				timezone text NOT NULL DEFAULT '',
+				imported_at timestamptz NOT NULL DEFAULT now()
+			);
+			CREATE INDEX osm_places_location_idx ON osm_places USING gist (location);`,
+		Down: `DROP TABLE osm_places;`,
+	},
+	{
+		Version: 11,
+		Name:    "create_user_profiles",
+		Up: `CREATE TABLE user_profiles (
+				psid text PRIMARY KEY,
+				dietary text[] NOT NULL DEFAULT '{}'
+			);`,
+		Down: `DROP TABLE user_profiles;`,
+	},
+	{
+		Version: 12,
+		Name:    "create_users",
+		Up: `CREATE TABLE users (
+				psid text PRIMARY KEY,
+				first_seen timestamptz NOT NULL DEFAULT now(),
+				locale text NOT NULL DEFAULT '',
+				dietary text[] NOT NULL DEFAULT '{}',
+				budget integer NOT NULL DEFAULT 0,
+				cuisines text[] NOT NULL DEFAULT '{}'
+			);
+			INSERT INTO users (psid, dietary) SELECT psid, dietary FROM user_profiles;
+			DROP TABLE user_profiles;`,
+		Down: `CREATE TABLE user_profiles (
+				psid text PRIMARY KEY,
+				dietary text[] NOT NULL DEFAULT '{}'
+			);
+			INSERT INTO user_profiles (psid, dietary) SELECT psid, dietary FROM users;
+			DROP TABLE users;`,
+	},
+	{
+		Version: 13,
+		Name:    "create_feedback_requests",
+		Up: `CREATE TABLE feedback_requests (
+				psid text NOT NULL,
+				googleid text NOT NULL,
+				send_at timestamptz NOT NULL,
+				PRIMARY KEY (psid, googleid)
+			);
+			CREATE INDEX feedback_requests_send_at_idx ON feedback_requests (send_at);`,
+		Down: `DROP TABLE feedback_requests;`,
+	},
+	{
+		Version: 14,
+		Name:    "add_user_blocked",
+		Up:      `ALTER TABLE users ADD COLUMN blocked boolean NOT NULL DEFAULT false;`,
+		Down:    `ALTER TABLE users DROP COLUMN blocked;`,
+	},
+	{
+		Version: 15,
+		Name:    "add_feedback_request_claimed_at",
+		Up:      `ALTER TABLE feedback_requests ADD COLUMN claimed_at timestamptz;`,
+		Down:    `ALTER TABLE feedback_requests DROP COLUMN claimed_at;`,
+	},
+	{
+		Version: 16,
+		Name:    "add_user_onboarded",
+		// Users saved before now were saved after Get Started or while
+		// choosing preferences, so they've already been welcomed.
+		Up: `ALTER TABLE users ADD COLUMN onboarded boolean NOT NULL DEFAULT false;
+			UPDATE users SET onboarded = true;`,
+		Down: `ALTER TABLE users DROP COLUMN onboarded;`,
+	},
+}
+
+// Migrate applies every migration newer than the current schema version.
+func Migrate(DB *sql.DB) error {
+	current, err := schemaVersion(DB)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		log.Printf("applying migration %d_%s", m.Version, m.Name)
+		err := inTransaction(DB, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1);", m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %s", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the most recent steps migrations.
+func Rollback(DB *sql.DB, steps int) error {
+	current, err := schemaVersion(DB)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if m.Version > current {
+			continue
+		}
+		log.Printf("reverting migration %d_%s", m.Version, m.Name)
+		err := inTransaction(DB, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1;", m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %s", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+func schemaVersion(DB *sql.DB) (int, error) {
+	_, err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	);`)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = DB.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;").Scan(&version)
+	return version, err
+}
+
+func inTransaction(DB *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
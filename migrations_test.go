@@ -0,0 +1,35 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// testDB connects to the Postgres database in TEST_DATABASE_URL and migrates
+// it, or skips the test if there isn't one. Tests clean up their own rows.
+func testDB(t *testing.T) *sql.DB {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("expected migration %s to have version %d, got %d", m.Name, i+1, m.Version)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("expected migration %d_%s to have up and down statements", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+
+	errPlaceNotFound = "place not found"
+	errInvalidStatus = "invalid status"
+	errEmptyEdit     = "nothing to change"
+
+	errInvalidCuratorScore = "curator score must be between 0 and 5"
+)
+
+// PlaceEdit holds the fields a moderator wants to change; nil fields are left
+// as they are.
+type PlaceEdit struct {
+	Name         *string  `json:"name"`
+	CuratorScore *float64 `json:"curator_score"`
+}
+
+type AuditEntry struct {
+	PlaceID   string                 `json:"place_id"`
+	Actor     string                 `json:"actor"`
+	Action    string                 `json:"action"`
+	Changes   map[string]interface{} `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+func validStatus(status string) bool {
+	return status == StatusPending || status == StatusApproved || status == StatusRejected
+}
+
+func validCuratorScore(score float64) bool {
+	return score >= 0 && score <= 5
+}
+
+// ListByStatus returns places awaiting or past moderation, oldest first.
+func (repo PlaceRepository) ListByStatus(status string) ([]Place, error) {
+	if !validStatus(status) {
+		return nil, errors.New(errInvalidStatus)
+	}
+	rows, err := repo.DB.Query(`SELECT `+placeColumns+` FROM places p
+		WHERE p.status = $1 ORDER BY p.recommended_at;`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var places []Place
+	for rows.Next() {
+		place, err := scanPlace(rows)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, place)
+	}
+	return places, rows.Err()
+}
+
+// SetStatus moves a place through moderation, recording who did it.
+func (repo PlaceRepository) SetStatus(placeID, status, actor string) error {
+	if !validStatus(status) {
+		return errors.New(errInvalidStatus)
+	}
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		var old string
+		err := tx.QueryRow("SELECT status FROM places WHERE googleid = $1 FOR UPDATE;", placeID).Scan(&old)
+		if err == sql.ErrNoRows {
+			return errors.New(errPlaceNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE places SET status = $2 WHERE googleid = $1;", placeID, status)
+		if err != nil {
+			return err
+		}
+		return insertAudit(tx, placeID, actor, status, map[string]interface{}{
+			"status": []string{old, status},
+		})
+	})
+}
+
+func (repo PlaceRepository) Edit(placeID string, edit PlaceEdit, actor string) error {
+	if edit.Name == nil && edit.CuratorScore == nil {
+		return errors.New(errEmptyEdit)
+	}
+	if edit.CuratorScore != nil && !validCuratorScore(*edit.CuratorScore) {
+		return errors.New(errInvalidCuratorScore)
+	}
+	return inTransaction(repo.DB, func(tx *sql.Tx) error {
+		var name string
+		var score sql.NullFloat64
+		err := tx.QueryRow("SELECT name, curator_score FROM places WHERE googleid = $1 FOR UPDATE;", placeID).Scan(&name, &score)
+		if err == sql.ErrNoRows {
+			return errors.New(errPlaceNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Only the edited columns are updated, so a place nobody has scored
+		// keeps a NULL curator score.
+		var set []string
+		args := []interface{}{placeID}
+		changes := make(map[string]interface{})
+		if edit.Name != nil {
+			args = append(args, *edit.Name)
+			set = append(set, fmt.Sprintf("name = $%d", len(args)))
+			changes["name"] = []string{name, *edit.Name}
+		}
+		if edit.CuratorScore != nil {
+			args = append(args, *edit.CuratorScore)
+			set = append(set, fmt.Sprintf("curator_score = $%d", len(args)))
+			var old interface{}
+			if score.Valid {
+				old = score.Float64
+			}
+			changes["curator_score"] = []interface{}{old, *edit.CuratorScore}
+		}
+
+		_, err = tx.Exec("UPDATE places SET "+strings.Join(set, ", ")+" WHERE googleid = $1;", args...)
+		if err != nil {
+			return err
+		}
+		return insertAudit(tx, placeID, actor, "edit", changes)
+	})
+}
+
+func (repo PlaceRepository) Audit(placeID string) ([]AuditEntry, error) {
+	rows, err := repo.DB.Query(`SELECT googleid, actor, action, changes, created_at
+		FROM place_audit WHERE googleid = $1 ORDER BY created_at;`, placeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+		if err := rows.Scan(&entry.PlaceID, &entry.Actor, &entry.Action, &changes, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func insertAudit(tx *sql.Tx, placeID, actor, action string, changes map[string]interface{}) error {
+	buf, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO place_audit (googleid, actor, action, changes) VALUES ($1, $2, $3, $4);",
+		placeID, actor, action, string(buf))
+	return err
+}
+
+// ModerationHandler serves the submissions queue:
+//
+//	GET   /admin/submissions?status=pending
+//	PATCH /admin/submissions/{id}
+//	POST  /admin/submissions/{id}/approve
+//	POST  /admin/submissions/{id}/reject
+//	GET   /admin/submissions/{id}/audit
+func (app *App) ModerationHandler(w http.ResponseWriter, r *http.Request) {
+	placeID, action := parseSubmissionPath(r.URL.Path)
+
+	switch {
+	case placeID == "" && r.Method == "GET":
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = StatusPending
+		}
+		places, err := NewPlaceRepository(app.DB).ListByStatus(status)
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, places)
+	case placeID != "" && action == "" && r.Method == "PATCH":
+		var edit PlaceEdit
+		if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := NewPlaceRepository(app.DB).Edit(placeID, edit, adminUser(r)); err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case placeID != "" && (action == "approve" || action == "reject") && r.Method == "POST":
+		status := StatusApproved
+		if action == "reject" {
+			status = StatusRejected
+		}
+		if err := NewPlaceRepository(app.DB).SetStatus(placeID, status, adminUser(r)); err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case placeID != "" && action == "audit" && r.Method == "GET":
+		entries, err := NewPlaceRepository(app.DB).Audit(placeID)
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	default:
+		writeJSONError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func parseSubmissionPath(path string) (placeID, action string) {
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(path, "/admin/submissions"), "/"), "/", 2)
+	placeID = parts[0]
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	return placeID, action
+}
+
+func writeModerationError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case errPlaceNotFound:
+		writeJSONError(w, http.StatusNotFound, err)
+	case errInvalidStatus, errEmptyEdit, errInvalidCuratorScore:
+		writeJSONError(w, http.StatusBadRequest, err)
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSubmissionPath(t *testing.T) {
+	tests := []struct {
+		Path    string
+		PlaceID string
+		Action  string
+	}{
+		{Path: "/admin/submissions"},
+		{Path: "/admin/submissions/"},
+		{Path: "/admin/submissions/ChIJ3bKbZvmipBIR", PlaceID: "ChIJ3bKbZvmipBIR"},
+		{Path: "/admin/submissions/ChIJ3bKbZvmipBIR/approve", PlaceID: "ChIJ3bKbZvmipBIR", Action: "approve"},
+	}
+
+	for _, test := range tests {
+		placeID, action := parseSubmissionPath(test.Path)
+		if placeID != test.PlaceID || action != test.Action {
+			t.Errorf("expected %q, %q for %s, got %q, %q", test.PlaceID, test.Action, test.Path, placeID, action)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	app := newApp(Config{AdminUsers: "nicola:s3cret, sam:pa55"}, nil, stubProvider{}, &fakeSender{})
+
+	var gotUser string
+	handler := app.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = adminUser(r)
+	})
+
+	tests := []struct {
+		User     string
+		Password string
+		Status   int
+	}{
+		{User: "sam", Password: "pa55", Status: http.StatusOK},
+		{User: "sam", Password: "s3cret", Status: http.StatusUnauthorized},
+		{User: "", Password: "", Status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		gotUser = ""
+		req := httptest.NewRequest("GET", "/admin/submissions", nil)
+		if test.User != "" {
+			req.SetBasicAuth(test.User, test.Password)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != test.Status {
+			t.Errorf("expected status %d for %s, got %d", test.Status, test.User, w.Code)
+		}
+		if test.Status == http.StatusOK && gotUser != test.User {
+			t.Errorf("expected admin user %s, got %s", test.User, gotUser)
+		}
+	}
+}
+
+func TestEditUpdatesOnlyEditedColumns(t *testing.T) {
+	name, score := "Bar Marsella", 4.5
+	tests := []struct {
+		Edit    PlaceEdit
+		Update  string
+		Args    []driver.Value
+		Changes string
+	}{
+		{
+			Edit:    PlaceEdit{Name: &name},
+			Update:  "UPDATE places SET name = $2 WHERE googleid = $1;",
+			Args:    []driver.Value{"ChIJ3bKbZvmipBIR", "Bar Marsella"},
+			Changes: `{"name":["Marsella","Bar Marsella"]}`,
+		},
+		{
+			Edit:    PlaceEdit{CuratorScore: &score},
+			Update:  "UPDATE places SET curator_score = $2 WHERE googleid = $1;",
+			Args:    []driver.Value{"ChIJ3bKbZvmipBIR", 4.5},
+			Changes: `{"curator_score":[null,4.5]}`,
+		},
+		{
+			Edit:    PlaceEdit{Name: &name, CuratorScore: &score},
+			Update:  "UPDATE places SET name = $2, curator_score = $3 WHERE googleid = $1;",
+			Args:    []driver.Value{"ChIJ3bKbZvmipBIR", "Bar Marsella", 4.5},
+			Changes: `{"curator_score":[null,4.5],"name":["Marsella","Bar Marsella"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t, fakeRows{
+			Columns: []string{"name", "curator_score"},
+			Values:  [][]driver.Value{{"Marsella", nil}},
+		})
+		if err := NewPlaceRepository(db).Edit("ChIJ3bKbZvmipBIR", test.Edit, "nicola"); err != nil {
+			t.Fatal(err)
+		}
+		queries := fake.Queries()
+		if len(queries) != 3 || queries[1] != test.Update {
+			t.Fatalf("expected update %q, got %v", test.Update, queries)
+		}
+		if args := fake.Statements[1].Args; !reflect.DeepEqual(args, test.Args) {
+			t.Errorf("expected update args %v, got %v", test.Args, args)
+		}
+		if changes := fake.Last().Args[3]; changes != test.Changes {
+			t.Errorf("expected audited changes %s, got %s", test.Changes, changes)
+		}
+	}
+}
+
+func TestModerationHandlerRejectsInvalidScores(t *testing.T) {
+	for _, body := range []string{`{"curator_score": 5.5}`, `{"curator_score": -1}`, `{}`} {
+		db, fake := newFakeDB(t)
+		app := newApp(Config{}, db, stubProvider{}, &fakeSender{})
+		req := httptest.NewRequest("PATCH", "/admin/submissions/ChIJ3bKbZvmipBIR", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		app.ModerationHandler(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+		if queries := fake.Queries(); len(queries) != 0 {
+			t.Errorf("expected no queries for %s, got %v", body, queries)
+		}
+	}
+}
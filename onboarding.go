@@ -0,0 +1,137 @@
+package main
+
+import "log"
+
+const (
+	// dietaryPayload prefixes the quick reply payload of a dietary
+	// requirement, e.g. "DIET:vegan", or "DIET:done" once there are no more.
+	dietaryPayload = "DIET:"
+	dietaryDone    = "done"
+)
+
+// welcomeMessages explain what the bot does to someone who has just tapped
+// Get Started.
+var welcomeMessages = []string{
+	"Hi! I'm Hungry Girl. I recommend places to eat and drink near you, picked by people who know the area.",
+	"Send me your location and I'll find somewhere open now, or tell me what you're after, like \"coffee\" or \"somewhere cheap\".",
+}
+
+var dietaryTitles = map[string]string{
+	"vegetarian":  "Vegetarian",
+	"vegan":       "Vegan",
+	"halal":       "Halal",
+	"kosher":      "Kosher",
+	"gluten_free": "Gluten free",
+}
+
+// handleGetStarted welcomes the user and, the first time, asks about their
+// dietary requirements before asking where they are. Users who have been
+// welcomed before keep the requirements they've already given.
+func (app *App) handleGetStarted(FBUserID string) {
+	for _, text := range welcomeMessages {
+		app.sendText(FBUserID, text)
+	}
+	app.saveLocale(FBUserID)
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+		return
+	}
+	if user.Onboarded {
+		app.sendLocationPrompt(FBUserID, "Send me your location and I'll find somewhere good to eat nearby.")
+		return
+	}
+	user.Onboarded = true
+	if err := app.Users.Save(user); err != nil {
+		log.Println("error saving user: ", err)
+	}
+	app.askDietary(FBUserID, "Do you have any dietary requirements?")
+}
+
+// askDietary asks for the user's dietary requirements, ticking those they've
+// already given so they can change their mind.
+func (app *App) askDietary(FBUserID, question string) {
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+	}
+	app.sendQuickReplies(FBUserID, question, dietaryQuickReplies(user.Dietary)...)
+}
+
+// localeFinder is implemented by senders that can look up a user's locale.
+type localeFinder interface {
+	UserLocale(user string) (string, error)
+}
+
+// saveLocale records the user's Messenger locale, if we can find it.
+func (app *App) saveLocale(FBUserID string) {
+	finder, ok := app.Messenger.(localeFinder)
+	if !ok {
+		return
+	}
+	locale, err := finder.UserLocale(FBUserID)
+	if err != nil {
+		log.Println("error getting user locale: ", err)
+		return
+	}
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+		return
+	}
+	user.Locale = locale
+	if err := app.Users.Save(user); err != nil {
+		log.Println("error saving user: ", err)
+	}
+}
+
+// handleDietaryReply adds or removes a dietary requirement and asks for any
+// more, or moves on to their location once they're done.
+func (app *App) handleDietaryReply(FBUserID, flag string) {
+	if flag == dietaryDone || !isDietaryFlag(flag) {
+		app.sendLocationPrompt(FBUserID, "Great! Now send me your location and I'll find somewhere good to eat nearby.")
+		return
+	}
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+		return
+	}
+	if containsString(user.Dietary, flag) {
+		user.Dietary = removeString(user.Dietary, flag)
+	} else {
+		user.Dietary = append(user.Dietary, flag)
+	}
+	if err := app.Users.Save(user); err != nil {
+		log.Println("error saving user: ", err)
+		return
+	}
+	app.sendQuickReplies(FBUserID, "Got it. Anything else?", dietaryQuickReplies(user.Dietary)...)
+}
+
+// dietaryQuickReplies offers every dietary requirement, ticking those already
+// chosen, so tapping one toggles it, then a way to finish.
+func dietaryQuickReplies(chosen []string) []FBQuickReply {
+	var replies []FBQuickReply
+	for _, flag := range dietaryFlags {
+		title := dietaryTitles[flag]
+		if containsString(chosen, flag) {
+			title = "✓ " + title
+		}
+		replies = append(replies, FBQuickReply{ContentType: "text", Title: title, Payload: dietaryPayload + flag})
+	}
+	done := "No thanks"
+	if len(chosen) > 0 {
+		done = "That's all"
+	}
+	return append(replies, FBQuickReply{ContentType: "text", Title: done, Payload: dietaryPayload + dietaryDone})
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeUserStore keeps users in memory, keyed by PSID.
+type fakeUserStore map[string]User
+
+func (f fakeUserStore) Get(psid string) (User, error) {
+	if user, ok := f[psid]; ok {
+		return user, nil
+	}
+	return User{PSID: psid}, nil
+}
+
+func (f fakeUserStore) Save(user User) error {
+	f[user.PSID] = user
+	return nil
+}
+
+func (f fakeUserStore) Seen(psid string) error {
+	user, ok := f[psid]
+	if !ok {
+		user = User{PSID: psid, FirstSeen: time.Now()}
+	}
+	user.Blocked = false
+	f[psid] = user
+	return nil
+}
+
+func (f fakeUserStore) SetBlocked(psid string, blocked bool) error {
+	user, _ := f.Get(psid)
+	user.Blocked = blocked
+	f[psid] = user
+	return nil
+}
+
+func TestHandleGetStarted(t *testing.T) {
+	sender := &fakeSender{}
+	users := fakeUserStore{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = users
+
+	app.handleGetStarted("1234")
+
+	expected := append(append([]string(nil), welcomeMessages...), "Do you have any dietary requirements?")
+	if !reflect.DeepEqual(sender.Log, expected) {
+		t.Errorf("expected %q, got %q", expected, sender.Log)
+	}
+	replies := sender.Sent[len(sender.Sent)-1].Message.QuickReplies
+	if len(replies) != len(dietaryFlags)+1 || replies[len(replies)-1].Payload != "DIET:done" {
+		t.Errorf("expected every dietary requirement and no thanks, got %v", replies)
+	}
+	if !users["1234"].Onboarded {
+		t.Errorf("expected the user to be marked onboarded")
+	}
+}
+
+func TestHandleGetStartedReturningUser(t *testing.T) {
+	sender := &fakeSender{}
+	users := fakeUserStore{"1234": {PSID: "1234", FirstSeen: time.Now(), Onboarded: true, Dietary: []string{"vegan"}}}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = users
+
+	app.handleGetStarted("1234")
+
+	last := sender.Sent[len(sender.Sent)-1].Message
+	if len(last.QuickReplies) != 1 || last.QuickReplies[0].ContentType != "location" {
+		t.Errorf("expected a location prompt, got %v", last)
+	}
+	if expected := []string{"vegan"}; !reflect.DeepEqual(users["1234"].Dietary, expected) {
+		t.Errorf("expected dietary requirements %v kept, got %v", expected, users["1234"].Dietary)
+	}
+}
+
+func TestHandleDietaryReply(t *testing.T) {
+	sender := &fakeSender{}
+	users := fakeUserStore{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = users
+
+	app.handleDietaryReply("1234", "vegan")
+	app.handleDietaryReply("1234", "halal")
+	app.handleDietaryReply("1234", "vegan")
+
+	if expected := []string{"halal"}; !reflect.DeepEqual(users["1234"].Dietary, expected) {
+		t.Errorf("expected %v saved, got %v", expected, users["1234"].Dietary)
+	}
+	var titles []string
+	for _, reply := range sender.Sent[len(sender.Sent)-1].Message.QuickReplies {
+		titles = append(titles, reply.Title)
+	}
+	expected := []string{"Vegetarian", "Vegan", "✓ Halal", "Kosher", "Gluten free", "That's all"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected %v offered, got %v", expected, titles)
+	}
+
+	app.handleDietaryReply("1234", dietaryDone)
+	last := sender.Sent[len(sender.Sent)-1].Message
+	if len(last.QuickReplies) != 1 || last.QuickReplies[0].ContentType != "location" {
+		t.Errorf("expected a location prompt, got %v", last)
+	}
+}
+
+func TestAskDietaryKeepsRequirements(t *testing.T) {
+	sender := &fakeSender{}
+	users := fakeUserStore{"1234": {PSID: "1234", Dietary: []string{"vegan"}}}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = users
+
+	app.handlePreferenceReply("1234", "dietary")
+
+	if expected := []string{"vegan"}; !reflect.DeepEqual(users["1234"].Dietary, expected) {
+		t.Errorf("expected %v kept, got %v", expected, users["1234"].Dietary)
+	}
+	replies := sender.Sent[0].Message.QuickReplies
+	if replies[1].Title != "✓ Vegan" || replies[1].Payload != "DIET:vegan" {
+		t.Errorf("expected vegan ticked, got %v", replies[1])
+	}
+}
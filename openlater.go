@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// openLaterCandidates is how many Google places are checked for opening
+	// hours when looking for somewhere open later.
+	openLaterCandidates = 10
+	tonightHour         = 20
+)
+
+var targetTimePattern = regexp.MustCompile(`(?i)\b(?:at\s+(\d{1,2})(?:[:.](\d{2}))?\s*(am|pm)?|(\d{1,2})(?:[:.](\d{2}))?\s*(am|pm))\b`)
+
+// TargetTime is a wall clock time the user wants somewhere to be open, in the
+// time zone of wherever they are searching.
+type TargetTime struct {
+	Hour    int
+	Minute  int
+	Tonight bool
+}
+
+// parseTargetTime finds "tonight" or a time such as "at 8pm", "8.30pm" or
+// "at 20:00" in text. A bare hour from 1 to 6, like "at 6", is taken to mean
+// the afternoon or evening; other bare hours are taken as they are.
+func parseTargetTime(text string) (TargetTime, bool) {
+	if m := targetTimePattern.FindStringSubmatch(text); m != nil {
+		hour, minute, meridiem := m[1], m[2], m[3]
+		if hour == "" {
+			hour, minute, meridiem = m[4], m[5], m[6]
+		}
+		h, _ := strconv.Atoi(hour)
+		min, _ := strconv.Atoi(minute)
+		if h > 23 || min > 59 || (meridiem != "" && (h == 0 || h > 12)) {
+			return TargetTime{}, false
+		}
+		switch {
+		case strings.EqualFold(meridiem, "am") && h == 12:
+			h = 0
+		case strings.EqualFold(meridiem, "pm") && h < 12:
+			h += 12
+		case meridiem == "" && h >= 1 && h <= 6:
+			h += 12
+		}
+		return TargetTime{Hour: h, Minute: min}, true
+	}
+	if strings.Contains(strings.ToLower(text), "tonight") {
+		return TargetTime{Hour: tonightHour, Tonight: true}, true
+	}
+	return TargetTime{}, false
+}
+
+func (t TargetTime) String() string {
+	if t.Tonight {
+		return "tonight"
+	}
+	return fmt.Sprintf("at %02d:%02d", t.Hour, t.Minute)
+}
+
+// After returns the next occurrence of t in now's time zone. Asking for
+// somewhere open tonight once the evening has started means now.
+func (t TargetTime) After(now time.Time) time.Time {
+	at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour, t.Minute, 0, 0, now.Location())
+	if !at.Before(now) {
+		return at
+	}
+	if t.Tonight {
+		return now
+	}
+	return at.AddDate(0, 0, 1)
+}
+
+// filterOpenLater keeps places that will be open at target in their own time
+// zone, flagging any that are closed now.
+func filterOpenLater(places []Place, now time.Time, target TargetTime) []Place {
+	var open []Place
+	for _, p := range places {
+		p.markOpening(target.After(p.localTime(now)))
+		if p.Closed {
+			continue
+		}
+		p.markOpening(now)
+		open = append(open, p)
+	}
+	return open
+}
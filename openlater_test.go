@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTargetTime(t *testing.T) {
+	tests := []struct {
+		Text     string
+		Expected TargetTime
+		Found    bool
+	}{
+		{Text: "Somewhere good tonight?", Expected: TargetTime{Hour: 20, Tonight: true}, Found: true},
+		{Text: "dinner at 8pm", Expected: TargetTime{Hour: 20}, Found: true},
+		{Text: "dinner at 6", Expected: TargetTime{Hour: 18}, Found: true},
+		{Text: "lunch at 1", Expected: TargetTime{Hour: 13}, Found: true},
+		{Text: "breakfast at 8", Expected: TargetTime{Hour: 8}, Found: true},
+		{Text: "brunch at 11", Expected: TargetTime{Hour: 11}, Found: true},
+		{Text: "lunch at 12:30", Expected: TargetTime{Hour: 12, Minute: 30}, Found: true},
+		{Text: "breakfast at 9am", Expected: TargetTime{Hour: 9}, Found: true},
+		{Text: "something for 7.45pm", Expected: TargetTime{Hour: 19, Minute: 45}, Found: true},
+		{Text: "at 21:00 tonight", Expected: TargetTime{Hour: 21}, Found: true},
+		{Text: "at 12am", Expected: TargetTime{Hour: 0}, Found: true},
+		{Text: "at 25:00"},
+		{Text: "13pm"},
+		{Text: "hello"},
+	}
+
+	for _, test := range tests {
+		got, found := parseTargetTime(test.Text)
+		if found != test.Found || got != test.Expected {
+			t.Errorf("expected %v, %t for %q, got %v, %t", test.Expected, test.Found, test.Text, got, found)
+		}
+	}
+}
+
+func TestTargetTimeAfter(t *testing.T) {
+	afternoon := time.Date(2017, 8, 8, 15, 0, 0, 0, time.UTC)
+	lateNight := time.Date(2017, 8, 8, 22, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		Target   TargetTime
+		Now      time.Time
+		Expected time.Time
+	}{
+		{Target: TargetTime{Hour: 20}, Now: afternoon, Expected: time.Date(2017, 8, 8, 20, 0, 0, 0, time.UTC)},
+		{Target: TargetTime{Hour: 20}, Now: lateNight, Expected: time.Date(2017, 8, 9, 20, 0, 0, 0, time.UTC)},
+		{Target: TargetTime{Hour: 20, Tonight: true}, Now: lateNight, Expected: lateNight},
+	}
+
+	for _, test := range tests {
+		if got := test.Target.After(test.Now); !got.Equal(test.Expected) {
+			t.Errorf("expected %v after %v to be %v, got %v", test.Target, test.Now, test.Expected, got)
+		}
+	}
+}
+
+func TestFilterOpenLater(t *testing.T) {
+	// Tuesday afternoon, when dinnerHours places are closed until 18:00.
+	now := time.Date(2017, 8, 8, 15, 0, 0, 0, time.UTC)
+	lunchHours := OpeningHours{
+		Periods: []OpeningPeriod{
+			{Open: DayTime{Day: 2, Time: "1200"}, Close: &DayTime{Day: 2, Time: "1600"}},
+		},
+	}
+	places := []Place{
+		{ID: "dinner", OpeningHours: &dinnerHours},
+		{ID: "lunch", OpeningHours: &lunchHours},
+	}
+
+	got := filterOpenLater(places, now, TargetTime{Hour: 20})
+	if len(got) != 1 || got[0].ID != "dinner" {
+		t.Fatalf("expected only the dinner place, got %v", got)
+	}
+	if got[0].OpeningNote != "Closed now – opens at 18:00" {
+		t.Errorf("expected dinner place to be flagged as closed now, got %q", got[0].OpeningNote)
+	}
+}
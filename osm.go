@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	errNoOSMExtract  = "OSM_EXTRACT is not set"
+	errNoOSMPhotos   = "OpenStreetMap has no photos"
+	earthRadiusMetre = 6371000
+	// nearbyRadiusMetres matches the radius of Google nearby searches.
+	nearbyRadiusMetres = 500
+)
+
+// osmDays are OpenStreetMap's day abbreviations, indexed by Google's day
+// numbers where 0 is Sunday.
+var osmDays = []string{"Su", "Mo", "Tu", "We", "Th", "Fr", "Sa"}
+
+var osmTimeRange = regexp.MustCompile(`^(\d{2}):(\d{2})-(\d{2}):(\d{2})$`)
+
+// OSMProvider serves places from an OpenStreetMap extract held in memory, so
+// searches need no API key. OpenStreetMap has no prices or photos.
+type OSMProvider struct {
+	Places []Place
+}
+
+type overpassResponse struct {
+	Elements []struct {
+		Type string            `json:"type"`
+		ID   int64             `json:"id"`
+		Lat  float64           `json:"lat"`
+		Lon  float64           `json:"lon"`
+		Tags map[string]string `json:"tags"`
+	} `json:"elements"`
+}
+
+// NewOSMProvider loads the Overpass JSON extract at path, whose opening hours
+// are in timezone.
+func NewOSMProvider(path, timezone string) (OSMProvider, error) {
+	if path == "" {
+		return OSMProvider{}, errors.New(errNoOSMExtract)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return OSMProvider{}, err
+	}
+	defer f.Close()
+	places, err := ReadOverpassJSON(f)
+	if err != nil {
+		return OSMProvider{}, fmt.Errorf("%s: %s", path, err)
+	}
+	setOSMTimezone(places, timezone)
+	return OSMProvider{Places: places}, nil
+}
+
+// ReadOverpassJSON reads the eating and drinking places from the JSON output
+// of an Overpass query, e.g. node[amenity~"restaurant|cafe|bar"](area);out;
+func ReadOverpassJSON(r io.Reader) ([]Place, error) {
+	var resp overpassResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, err
+	}
+	var places []Place
+	for _, e := range resp.Elements {
+		if e.Type != "node" {
+			continue
+		}
+		if place, ok := osmPlace(fmt.Sprintf("node/%d", e.ID), e.Lat, e.Lon, e.Tags); ok {
+			places = append(places, place)
+		}
+	}
+	return places, nil
+}
+
+// osmPlace makes a place from a named OpenStreetMap node we'd recommend.
+func osmPlace(id string, lat, lon float64, tags map[string]string) (Place, bool) {
+	placeType := osmPlaceType(tags)
+	if placeType == "" || tags["name"] == "" {
+		return Place{}, false
+	}
+	place := Place{
+		ID:       providerIDPrefixes[providerOSM] + id,
+		Name:     tags["name"],
+		Website:  tags["website"],
+		Location: Location{Latitude: lat, Longitude: lon},
+		Types:    []string{placeType},
+		Cuisines: splitOSMList(tags["cuisine"]),
+	}
+	if hours, ok := parseOSMOpeningHours(tags["opening_hours"]); ok {
+		place.OpeningHours = hours
+	}
+	return place, true
+}
+
+// setOSMTimezone records the time zone of places' opening hours, which
+// OpenStreetMap leaves to local knowledge.
+func setOSMTimezone(places []Place, timezone string) {
+	for i := range places {
+		places[i].Timezone = timezone
+	}
+}
+
+func osmPlaceType(tags map[string]string) string {
+	switch tags["amenity"] {
+	case "restaurant":
+		return "restaurant"
+	case "cafe":
+		return "cafe"
+	case "bar", "pub":
+		return "bar"
+	case "fast_food":
+		return "meal_takeaway"
+	}
+	if tags["shop"] == "bakery" {
+		return "bakery"
+	}
+	return ""
+}
+
+func splitOSMList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseOSMOpeningHours understands the common opening_hours forms, such as
+// "24/7" and "Mo-Fr 12:00-15:00,19:00-23:00; Sa 19:00-01:00; Su off". Later
+// rules replace earlier ones for the days they name. Anything else is left
+// unknown.
+func parseOSMOpeningHours(s string) (*OpeningHours, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false
+	}
+	if s == "24/7" {
+		return &OpeningHours{Periods: []OpeningPeriod{{Open: DayTime{Day: 0, Time: "0000"}}}}, true
+	}
+
+	var days [7][]OpeningPeriod
+	for _, rule := range strings.Split(s, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		ruleDays := []int{0, 1, 2, 3, 4, 5, 6}
+		if !strings.ContainsAny(fields[0][:1], "0123456789") {
+			var ok bool
+			if ruleDays, ok = parseOSMDays(fields[0]); !ok {
+				return nil, false
+			}
+			fields = fields[1:]
+		}
+		times := strings.Join(fields, "")
+		if times == "" {
+			return nil, false
+		}
+		var periods []OpeningPeriod
+		if times != "off" && times != "closed" {
+			for _, r := range strings.Split(times, ",") {
+				m := osmTimeRange.FindStringSubmatch(r)
+				if m == nil {
+					return nil, false
+				}
+				periods = append(periods, OpeningPeriod{
+					Open:  DayTime{Time: m[1] + m[2]},
+					Close: &DayTime{Time: m[3] + m[4]},
+				})
+			}
+		}
+		for _, day := range ruleDays {
+			days[day] = periods
+		}
+	}
+
+	hours := &OpeningHours{}
+	for day, periods := range days {
+		for _, p := range periods {
+			period, ok := dailyPeriod(day, p.Open.Time, p.Close.Time)
+			if !ok {
+				return nil, false
+			}
+			hours.Periods = append(hours.Periods, period)
+		}
+	}
+	return hours, true
+}
+
+// parseOSMDays reads days like "Mo-Fr" or "Sa,Su" as Google day numbers.
+func parseOSMDays(s string) ([]int, bool) {
+	var days []int
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return nil, false
+		}
+		first, last := osmDay(bounds[0]), osmDay(bounds[len(bounds)-1])
+		if first == -1 || last == -1 {
+			return nil, false
+		}
+		// Mo is 1 and Su is 0, so walk from first to last in OSM's week order.
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, true
+}
+
+func osmDay(s string) int {
+	for i, day := range osmDays {
+		if s == day {
+			return i
+		}
+	}
+	return -1
+}
+
+func (osm OSMProvider) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	placeType := search.Type
+	if placeType == "" {
+		placeType = defaultPlaceType
+	}
+	return osm.find(func(p Place) bool {
+		return hasType(p, placeType)
+	}, &l, nearbyRadiusMetres, search.OpenNow, search.Limit), nil
+}
+
+// TextSearch matches query words against names and cuisines.
+func (osm OSMProvider) TextSearch(q TextQuery) ([]Place, error) {
+	radius := q.Radius
+	if radius == 0 {
+		radius = textSearchRadius
+	}
+	words := queryWords(q.Query)
+	return osm.find(func(p Place) bool {
+		if q.Type != "" && !hasType(p, q.Type) {
+			return false
+		}
+		text := strings.ToLower(p.Name + " " + strings.Join(p.Cuisines, " "))
+		for _, word := range words {
+			if strings.Contains(text, strings.TrimSuffix(word, "s")) {
+				return true
+			}
+		}
+		return false
+	}, q.Location, radius, q.OpenNow, q.Limit), nil
+}
+
+// find returns the matching places within radius metres of location, nearest
+// first, or in extract order if there's no location.
+func (osm OSMProvider) find(match func(Place) bool, location *Location, radius int, openNow bool, limit int) []Place {
+	type candidate struct {
+		place    Place
+		distance float64
+	}
+	var candidates []candidate
+	now := time.Now()
+	for _, p := range osm.Places {
+		if !match(p) {
+			continue
+		}
+		var distance float64
+		if location != nil {
+			distance = distanceMetres(*location, p.Location)
+			if distance > float64(radius) {
+				continue
+			}
+		}
+		if openNow {
+			if p.markOpening(now); p.Closed {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate{place: p, distance: distance})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var places []Place
+	for _, c := range candidates {
+		if limit > 0 && len(places) == limit {
+			break
+		}
+		places = append(places, c.place)
+	}
+	return places
+}
+
+func (osm OSMProvider) Details(placeID string) (Place, error) {
+	for _, p := range osm.Places {
+		if p.ID == placeID {
+			return p, nil
+		}
+	}
+	return Place{}, errors.New(errPlaceNotFound)
+}
+
+func (osm OSMProvider) Photo(reference string, maxWidth int) (string, error) {
+	return "", errors.New(errNoOSMPhotos)
+}
+
+func hasType(p Place, placeType string) bool {
+	for _, t := range p.Types {
+		if t == placeType {
+			return true
+		}
+	}
+	return false
+}
+
+// distanceMetres is the great circle distance between a and b.
+func distanceMetres(a, b Location) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMetre * math.Asin(math.Sqrt(h))
+}
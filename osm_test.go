@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const overpassFixture = `{
+  "version": 0.6,
+  "elements": [
+    {"type": "node", "id": 1, "lat": 41.3782, "lon": 2.1718,
+     "tags": {"amenity": "bar", "name": "Bar Marsella", "opening_hours": "24/7"}},
+    {"type": "node", "id": 2, "lat": 41.3839, "lon": 2.1826,
+     "tags": {"amenity": "restaurant", "name": "Cal Pep", "cuisine": "tapas;seafood"}},
+    {"type": "node", "id": 3, "lat": 41.3785, "lon": 2.1720,
+     "tags": {"amenity": "restaurant", "name": "Bar Ramon", "cuisine": "tapas"}},
+    {"type": "node", "id": 4, "lat": 41.3785, "lon": 2.1720, "tags": {"amenity": "bench"}},
+    {"type": "way", "id": 5, "tags": {"amenity": "cafe", "name": "Satan's Coffee Corner"}}
+  ]
+}`
+
+func TestOSMProvider(t *testing.T) {
+	places, err := ReadOverpassJSON(strings.NewReader(overpassFixture))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	setOSMTimezone(places, "Europe/Madrid")
+	if len(places) != 3 {
+		t.Fatalf("expected three places, got %v", places)
+	}
+	osm := OSMProvider{Places: places}
+
+	nearby, _ := osm.Nearby(Location{Latitude: 41.3782, Longitude: 2.1718}, NearbySearch{Limit: 3})
+	if len(nearby) != 1 || nearby[0].Name != "Bar Ramon" {
+		t.Errorf("expected the one restaurant within 500m, got %v", nearby)
+	}
+	found, _ := osm.TextSearch(TextQuery{Query: "tapas", Location: &Location{Latitude: 41.3839, Longitude: 2.1826}, Radius: 2000})
+	if len(found) != 2 || found[0].Name != "Cal Pep" {
+		t.Errorf("expected both tapas places, nearest first, got %v", found)
+	}
+	details, err := osm.Details("osm:node/2")
+	if err != nil || !reflect.DeepEqual(details.Cuisines, []string{"tapas", "seafood"}) {
+		t.Errorf("expected Cal Pep's details, got %v, %v", details, err)
+	}
+}
+
+func TestParseOSMOpeningHours(t *testing.T) {
+	tests := []struct {
+		Hours    string
+		Expected []OpeningPeriod
+		Known    bool
+	}{
+		{Hours: "24/7", Expected: []OpeningPeriod{{Open: DayTime{Day: 0, Time: "0000"}}}, Known: true},
+		{
+			Hours: "Mo-Sa 19:00-01:00; Sa 12:00-15:00, 19:00-02:00; Su off",
+			Expected: []OpeningPeriod{
+				{Open: DayTime{Day: 1, Time: "1900"}, Close: &DayTime{Day: 2, Time: "0100"}},
+				{Open: DayTime{Day: 2, Time: "1900"}, Close: &DayTime{Day: 3, Time: "0100"}},
+				{Open: DayTime{Day: 3, Time: "1900"}, Close: &DayTime{Day: 4, Time: "0100"}},
+				{Open: DayTime{Day: 4, Time: "1900"}, Close: &DayTime{Day: 5, Time: "0100"}},
+				{Open: DayTime{Day: 5, Time: "1900"}, Close: &DayTime{Day: 6, Time: "0100"}},
+				{Open: DayTime{Day: 6, Time: "1200"}, Close: &DayTime{Day: 6, Time: "1500"}},
+				{Open: DayTime{Day: 6, Time: "1900"}, Close: &DayTime{Day: 0, Time: "0200"}},
+			},
+			Known: true,
+		},
+		{Hours: "Mo-Fr 08:00-12:00; PH off"},
+		{Hours: "sunrise-sunset"},
+		{Hours: ""},
+	}
+
+	for _, test := range tests {
+		got, known := parseOSMOpeningHours(test.Hours)
+		if known != test.Known || (known && !reflect.DeepEqual(got.Periods, test.Expected)) {
+			t.Errorf("expected %v, %t for %q, got %v, %t", test.Expected, test.Known, test.Hours, got, known)
+		}
+	}
+}
+
+func TestReadOSMGeoJSON(t *testing.T) {
+	geojson := `{
+	  "type": "FeatureCollection",
+	  "features": [
+	    {"type": "Feature", "id": "node/1", "geometry": {"type": "Point", "coordinates": [2.1718, 41.3782]},
+	     "properties": {"amenity": "bar", "name": "Bar Marsella"}},
+	    {"type": "Feature", "geometry": {"type": "Point", "coordinates": [2.1826, 41.3839]},
+	     "properties": {"osm_id": 2, "amenity": "restaurant", "name": "Cal Pep"}},
+	    {"type": "Feature", "id": "way/3", "geometry": {"type": "Polygon", "coordinates": []},
+	     "properties": {"amenity": "cafe", "name": "Satan's Coffee Corner"}}
+	  ]
+	}`
+
+	places, err := ReadOSMPlaces(strings.NewReader(geojson), osmFormatFromFilename("barcelona.geojson"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(places) != 2 || places[0].ID != "osm:node/1" || places[1].ID != "osm:node/2" {
+		t.Fatalf("expected Bar Marsella and Cal Pep, got %v", places)
+	}
+	if places[1].Location != (Location{Latitude: 41.3839, Longitude: 2.1826}) {
+		t.Errorf("expected Cal Pep's location, got %v", places[1].Location)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	formatPBF      = "pbf"
+	formatOverpass = "overpass"
+	metresPerMile  = 1609.344
+	// osmMaxBatches is how many batches of nearbyCandidates places are read
+	// looking for enough that are open.
+	osmMaxBatches = 5
+)
+
+const osmPlaceColumns = `id, name, location[1], location[0], types, cuisines, website, opening_hours, timezone`
+
+// OSMIndexProvider serves places from the osm_places table, loaded from an
+// OpenStreetMap extract with the "osm import" command.
+type OSMIndexProvider struct {
+	DB *sql.DB
+}
+
+type osmGeoJSONCollection struct {
+	Features []struct {
+		ID       interface{}            `json:"id"`
+		Geometry GeoJSONPoint           `json:"geometry"`
+		Props    map[string]interface{} `json:"properties"`
+	} `json:"features"`
+}
+
+// osmFormatFromFilename guesses the format of an OpenStreetMap extract.
+func osmFormatFromFilename(filename string) string {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".pbf"):
+		return formatPBF
+	case strings.HasSuffix(lower, ".geojson"):
+		return formatGeoJSON
+	default:
+		return formatOverpass
+	}
+}
+
+func ReadOSMPlaces(r io.Reader, format string) ([]Place, error) {
+	switch format {
+	case formatPBF:
+		return ReadOSMPBF(r)
+	case formatGeoJSON:
+		return ReadOSMGeoJSON(r)
+	case formatOverpass:
+		return ReadOverpassJSON(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// ReadOSMGeoJSON reads points whose properties are OpenStreetMap tags, as
+// written by osmtogeojson or ogr2ogr. Features are identified by their id,
+// "@id" or "osm_id".
+func ReadOSMGeoJSON(r io.Reader) ([]Place, error) {
+	var collection osmGeoJSONCollection
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&collection); err != nil {
+		return nil, err
+	}
+	var places []Place
+	for i, feature := range collection.Features {
+		if feature.Geometry.Type != "Point" || len(feature.Geometry.Coordinates) != 2 {
+			continue
+		}
+		tags := make(map[string]string)
+		for key, value := range feature.Props {
+			if value != nil {
+				tags[key] = fmt.Sprint(value)
+			}
+		}
+		var id string
+		switch {
+		case feature.ID != nil:
+			id = fmt.Sprint(feature.ID)
+		case tags["@id"] != "":
+			id = tags["@id"]
+		case tags["osm_id"] != "":
+			id = tags["osm_id"]
+		default:
+			return nil, fmt.Errorf("feature %d: missing id", i)
+		}
+		if !strings.Contains(id, "/") {
+			id = "node/" + id
+		}
+		coords := feature.Geometry.Coordinates
+		if place, ok := osmPlace(id, coords[1], coords[0], tags); ok {
+			places = append(places, place)
+		}
+	}
+	return places, nil
+}
+
+// SaveOSMPlaces replaces the places loaded from OpenStreetMap with places.
+func SaveOSMPlaces(DB *sql.DB, places []Place) error {
+	return inTransaction(DB, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM osm_places;"); err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(`INSERT INTO osm_places (id, name, location, types, cuisines, website, opening_hours, timezone)
+			VALUES ($1, $2, POINT($3, $4), $5, $6, $7, $8, $9) ON CONFLICT (id) DO NOTHING;`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, p := range places {
+			_, err := stmt.Exec(p.ID, p.Name, p.Location.Longitude, p.Location.Latitude,
+				pq.Array(nonNil(p.Types)), pq.Array(nonNil(p.Cuisines)), p.Website,
+				openingHoursJSON(p.OpeningHours), p.Timezone)
+			if err != nil {
+				return fmt.Errorf("%s: %s", p.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (osm OSMIndexProvider) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	placeType := search.Type
+	if placeType == "" {
+		placeType = defaultPlaceType
+	}
+	return osm.query(`SELECT `+osmPlaceColumns+` FROM osm_places
+		WHERE location <@> POINT($1, $2) < $3 AND $4 = ANY(types)
+		ORDER BY location <@> POINT($1, $2) LIMIT $5 OFFSET $6;`,
+		[]interface{}{l.Longitude, l.Latitude, nearbyRadiusMetres / metresPerMile, placeType},
+		search.OpenNow, search.Limit)
+}
+
+// TextSearch matches query words against names and cuisines.
+func (osm OSMIndexProvider) TextSearch(q TextQuery) ([]Place, error) {
+	var patterns, words []string
+	for _, word := range queryWords(q.Query) {
+		patterns = append(patterns, "%"+strings.TrimSuffix(word, "s")+"%")
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{pq.Array(patterns), pq.Array(words)}
+	where := []string{"(name ILIKE ANY($1) OR cuisines && $2)"}
+	order := "name"
+	if q.Type != "" {
+		args = append(args, q.Type)
+		where = append(where, fmt.Sprintf("$%d = ANY(types)", len(args)))
+	}
+	if q.Location != nil {
+		radius := q.Radius
+		if radius == 0 {
+			radius = textSearchRadius
+		}
+		args = append(args, q.Location.Longitude, q.Location.Latitude, float64(radius)/metresPerMile)
+		distance := fmt.Sprintf("location <@> POINT($%d, $%d)", len(args)-2, len(args)-1)
+		where = append(where, fmt.Sprintf("%s < $%d", distance, len(args)))
+		order = distance
+	}
+	query := fmt.Sprintf(`SELECT %s FROM osm_places WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d;`,
+		osmPlaceColumns, strings.Join(where, " AND "), order, len(args)+1, len(args)+2)
+	return osm.query(query, args, q.OpenNow, q.Limit)
+}
+
+// query runs a query ending in LIMIT and OFFSET placeholders following args,
+// reading batches of nearbyCandidates places until limit are found that are
+// open, if openNow. Without a limit, only the first batch is read.
+func (osm OSMIndexProvider) query(query string, args []interface{}, openNow bool, limit int) ([]Place, error) {
+	var places []Place
+	for batch := 0; batch < osmMaxBatches; batch++ {
+		batchArgs := append(append([]interface{}{}, args...), nearbyCandidates, batch*nearbyCandidates)
+		rows, err := osm.DB.Query(query, batchArgs...)
+		if err != nil {
+			return nil, err
+		}
+		found, scanned, err := scanOSMPlaces(rows, openNow)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, found...)
+		if limit > 0 && len(places) >= limit {
+			return places[:limit], nil
+		}
+		if limit == 0 || scanned < nearbyCandidates {
+			break
+		}
+	}
+	return places, nil
+}
+
+func (osm OSMIndexProvider) Details(placeID string) (Place, error) {
+	rows, err := osm.DB.Query(`SELECT `+osmPlaceColumns+` FROM osm_places WHERE id = $1;`, placeID)
+	if err != nil {
+		return Place{}, err
+	}
+	places, _, err := scanOSMPlaces(rows, false)
+	if err != nil {
+		return Place{}, err
+	}
+	if len(places) == 0 {
+		return Place{}, errors.New(errPlaceNotFound)
+	}
+	return places[0], nil
+}
+
+func (osm OSMIndexProvider) Photo(reference string, maxWidth int) (string, error) {
+	return "", errors.New(errNoOSMPhotos)
+}
+
+// scanOSMPlaces reads places, skipping closed ones if openNow, and returns
+// how many rows it read.
+func scanOSMPlaces(rows *sql.Rows, openNow bool) ([]Place, int, error) {
+	defer rows.Close()
+	now := time.Now()
+	var places []Place
+	scanned := 0
+	for rows.Next() {
+		scanned++
+		var place Place
+		var hours []byte
+		err := rows.Scan(&place.ID, &place.Name, &place.Location.Latitude, &place.Location.Longitude,
+			pq.Array(&place.Types), pq.Array(&place.Cuisines), &place.Website, &hours, &place.Timezone)
+		if err != nil {
+			return nil, 0, err
+		}
+		if hours != nil {
+			place.OpeningHours = &OpeningHours{}
+			if err := json.Unmarshal(hours, place.OpeningHours); err != nil {
+				return nil, 0, err
+			}
+		}
+		if openNow {
+			if place.markOpening(now); place.Closed {
+				continue
+			}
+		}
+		places = append(places, place)
+	}
+	return places, scanned, rows.Err()
+}
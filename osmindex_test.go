@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// osmRow is a row of osmPlaceColumns for a restaurant with hours, which may
+// be nil.
+func osmRow(id string, hours *OpeningHours) []driver.Value {
+	var hoursJSON driver.Value
+	if hours != nil {
+		hoursJSON = []byte(openingHoursJSON(hours).(string))
+	}
+	return []driver.Value{id, "Bar " + id, 41.38, 2.17, "{restaurant}", "{}", "", hoursJSON, "UTC"}
+}
+
+// closedNow opens in an hour, for an hour, every day of the week.
+func closedNow() *OpeningHours {
+	opens, closes := time.Now().UTC().Add(time.Hour), time.Now().UTC().Add(2*time.Hour)
+	hours := &OpeningHours{}
+	for day := 0; day < 7; day++ {
+		hours.Periods = append(hours.Periods, OpeningPeriod{
+			Open:  DayTime{Day: (int(opens.Weekday()) + day) % 7, Time: opens.Format("1504")},
+			Close: &DayTime{Day: (int(closes.Weekday()) + day) % 7, Time: closes.Format("1504")},
+		})
+	}
+	return hours
+}
+
+func TestOSMIndexNearbyReadsBatchesUntilEnoughAreOpen(t *testing.T) {
+	var first [][]driver.Value
+	for i := 0; i < nearbyCandidates-1; i++ {
+		first = append(first, osmRow(fmt.Sprintf("node/%d", i), closedNow()))
+	}
+	first = append(first, osmRow("node/open1", nil))
+	second := [][]driver.Value{osmRow("node/open2", nil), osmRow("node/closed", closedNow()), osmRow("node/open3", nil), osmRow("node/open4", nil)}
+	db, fake := newFakeDB(t, fakeRows{Values: first}, fakeRows{Values: second})
+
+	places, err := OSMIndexProvider{DB: db}.Nearby(Location{Latitude: 41.38, Longitude: 2.17}, NearbySearch{OpenNow: true, Limit: 3, Type: "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, p := range places {
+		ids = append(ids, p.ID)
+	}
+	if expected := []string{"node/open1", "node/open2", "node/open3"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+
+	expected := "SELECT " + osmPlaceColumns + " FROM osm_places WHERE location <@> POINT($1, $2) < $3 AND $4 = ANY(types) ORDER BY location <@> POINT($1, $2) LIMIT $5 OFFSET $6;"
+	queries := fake.Queries()
+	if len(queries) != 2 || queries[0] != expected || queries[1] != expected {
+		t.Fatalf("expected two batches of %q, got %v", expected, queries)
+	}
+	radius := nearbyRadiusMetres / metresPerMile
+	for i, offset := range []int64{0, nearbyCandidates} {
+		args := []driver.Value{2.17, 41.38, radius, "bar", int64(nearbyCandidates), offset}
+		if got := fake.Statements[i].Args; !reflect.DeepEqual(got, args) {
+			t.Errorf("expected batch %d args %v, got %v", i, args, got)
+		}
+	}
+}
+
+func TestOSMIndexTextSearchQuery(t *testing.T) {
+	radius := 1609
+	tests := []struct {
+		Query TextQuery
+		Where string
+		Order string
+		Args  []driver.Value
+	}{
+		{
+			Query: TextQuery{Query: "best dumplings"},
+			Where: "(name ILIKE ANY($1) OR cuisines && $2)",
+			Order: "name LIMIT $3 OFFSET $4",
+			Args:  []driver.Value{`{"%dumpling%"}`, `{"dumplings"}`, int64(nearbyCandidates), int64(0)},
+		},
+		{
+			Query: TextQuery{Query: "ramen", Type: "restaurant", Location: &Location{Latitude: 51.5, Longitude: -0.13}, Radius: radius},
+			Where: "(name ILIKE ANY($1) OR cuisines && $2) AND $3 = ANY(types) AND location <@> POINT($4, $5) < $6",
+			Order: "location <@> POINT($4, $5) LIMIT $7 OFFSET $8",
+			Args:  []driver.Value{`{"%ramen%"}`, `{"ramen"}`, "restaurant", -0.13, 51.5, float64(radius) / metresPerMile, int64(nearbyCandidates), int64(0)},
+		},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t)
+		if _, err := (OSMIndexProvider{DB: db}).TextSearch(test.Query); err != nil {
+			t.Fatal(err)
+		}
+		expected := "SELECT " + osmPlaceColumns + " FROM osm_places WHERE " + test.Where + " ORDER BY " + test.Order + ";"
+		last := fake.Last()
+		if last.Query != expected {
+			t.Errorf("expected %q for %q, got %q", expected, test.Query.Query, last.Query)
+		}
+		if !reflect.DeepEqual(last.Args, test.Args) {
+			t.Errorf("expected args %v for %q, got %v", test.Args, test.Query.Query, last.Args)
+		}
+	}
+
+	db, fake := newFakeDB(t)
+	if places, err := (OSMIndexProvider{DB: db}).TextSearch(TextQuery{Query: "somewhere good"}); places != nil || err != nil {
+		t.Errorf("expected no places for filler words, got %v, %v", places, err)
+	}
+	if len(fake.Statements) != 0 {
+		t.Errorf("expected no query for filler words, got %v", fake.Queries())
+	}
+}
+
+func TestSaveOSMPlaces(t *testing.T) {
+	db, fake := newFakeDB(t)
+	places := []Place{
+		{ID: "osm:node/1", Name: "Bar Marsella", Location: Location{Latitude: 41.3782, Longitude: 2.1718}, Types: []string{"bar"}},
+		{ID: "osm:node/2", Name: "Cal Pep", Location: Location{Latitude: 41.3838, Longitude: 2.1821}, Cuisines: []string{"catalan"},
+			OpeningHours: &OpeningHours{Periods: []OpeningPeriod{{Open: DayTime{Day: 0, Time: "0000"}}}}, Timezone: "Europe/Madrid"},
+	}
+	if err := SaveOSMPlaces(db, places); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := fake.Queries()
+	insert := "INSERT INTO osm_places (id, name, location, types, cuisines, website, opening_hours, timezone) VALUES ($1, $2, POINT($3, $4), $5, $6, $7, $8, $9) ON CONFLICT (id) DO NOTHING;"
+	if expected := []string{"DELETE FROM osm_places;", insert, insert}; !reflect.DeepEqual(queries, expected) {
+		t.Fatalf("expected %v, got %v", expected, queries)
+	}
+	expected := []driver.Value{"osm:node/2", "Cal Pep", 2.1821, 41.3838, "{}", `{"catalan"}`, "",
+		`{"periods":[{"open":{"day":0,"time":"0000"}}]}`, "Europe/Madrid"}
+	if args := fake.Last().Args; !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected args %v, got %v", expected, args)
+	}
+	if args := fake.Statements[1].Args; args[4] != `{"bar"}` || args[7] != nil {
+		t.Errorf("expected a bar without opening hours, got %v", args)
+	}
+}
+
+func TestRecommendWithOnlyOSM(t *testing.T) {
+	db, fake := newFakeDB(t,
+		fakeRows{Values: [][]driver.Value{osmRow("node/1", nil)}},
+		fakeRows{Values: [][]driver.Value{curatedRow("ChIJ3bKbZvmipBIR", "Bar Marsella", int64(0))}},
+		fakeRows{Values: [][]driver.Value{osmRow("node/1", nil)}},
+	)
+	sender := &fakeSender{}
+	app := newApp(Config{}, db, NewPlacesProvider(Config{PlacesProviders: []string{providerOSM}}, db), sender)
+	app.Users = fakeUserStore{}
+
+	app.recommend("123", Location{Latitude: 41.38, Longitude: 2.17}, SearchRequest{})
+
+	var titles []string
+	for _, sent := range sender.Sent {
+		if sent.Message.Attachment != nil {
+			titles = append(titles, sent.Message.Attachment.Payload.Elements[0].Title)
+		}
+	}
+	if expected := []string{"Bar Marsella", "Bar node/1"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("expected cards for %v, got %v from %v", expected, titles, sender.Log)
+	}
+	for _, statement := range fake.Statements {
+		for _, arg := range statement.Args {
+			if arg == "ChIJ3bKbZvmipBIR" {
+				t.Errorf("expected the curated place's Google ID not to be looked up, got %s", statement.Query)
+			}
+		}
+	}
+}
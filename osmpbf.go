@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// maxBlobHeaderSize and maxBlobSize are the limits set by the OSM PBF
+	// format.
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtobuf = errors.New("invalid protobuf")
+
+// ReadOSMPBF reads the places we'd recommend from the nodes of an
+// OpenStreetMap PBF extract, such as one from download.geofabrik.de. Ways and
+// relations are skipped.
+func ReadOSMPBF(r io.Reader) ([]Place, error) {
+	br := bufio.NewReader(r)
+	var places []Place
+	for {
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &size); err == io.EOF {
+			return places, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if size > maxBlobHeaderSize {
+			return nil, errors.New("pbf blob header too large")
+		}
+		header := make([]byte, size)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, err
+		}
+		blobType, dataSize, err := parseBlobHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if dataSize > maxBlobSize {
+			return nil, errors.New("pbf blob too large")
+		}
+		blob := make([]byte, dataSize)
+		if _, err := io.ReadFull(br, blob); err != nil {
+			return nil, err
+		}
+		if blobType != "OSMData" {
+			continue
+		}
+		data, err := blobData(blob)
+		if err != nil {
+			return nil, err
+		}
+		found, err := primitiveBlockPlaces(data)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, found...)
+	}
+}
+
+func parseBlobHeader(buf []byte) (string, int, error) {
+	var blobType string
+	var dataSize int
+	err := eachField(buf, func(field int, wire int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			blobType = string(b)
+		case 3:
+			dataSize = int(v)
+		}
+		return nil
+	})
+	return blobType, dataSize, err
+}
+
+// blobData returns a blob's raw or zlib compressed contents.
+func blobData(buf []byte) ([]byte, error) {
+	var raw, compressed []byte
+	err := eachField(buf, func(field int, wire int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			raw = b
+		case 3:
+			compressed = b
+		case 4, 5, 6, 7:
+			return errors.New("unsupported pbf compression")
+		}
+		return nil
+	})
+	if err != nil || raw != nil {
+		return raw, err
+	}
+	if compressed == nil {
+		return nil, errors.New("empty pbf blob")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(io.LimitReader(zr, maxBlobSize))
+}
+
+type primitiveBlock struct {
+	strings     []string
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b primitiveBlock) coordinate(offset, v int64) float64 {
+	return 1e-9 * float64(offset+b.granularity*v)
+}
+
+func (b primitiveBlock) tags(keys, vals []uint64) map[string]string {
+	tags := make(map[string]string)
+	for i := range keys {
+		if i < len(vals) && int(keys[i]) < len(b.strings) && int(vals[i]) < len(b.strings) {
+			tags[b.strings[keys[i]]] = b.strings[vals[i]]
+		}
+	}
+	return tags
+}
+
+func primitiveBlockPlaces(buf []byte) ([]Place, error) {
+	block := primitiveBlock{granularity: 100}
+	var groups [][]byte
+	err := eachField(buf, func(field int, wire int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			return eachField(b, func(field int, wire int, v uint64, s []byte) error {
+				if field == 1 {
+					block.strings = append(block.strings, string(s))
+				}
+				return nil
+			})
+		case 2:
+			groups = append(groups, b)
+		case 17:
+			block.granularity = int64(v)
+		case 19:
+			block.latOffset = int64(v)
+		case 20:
+			block.lonOffset = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var places []Place
+	add := func(id, lat, lon int64, tags map[string]string) {
+		place, ok := osmPlace(fmt.Sprintf("node/%d", id), block.coordinate(block.latOffset, lat), block.coordinate(block.lonOffset, lon), tags)
+		if ok {
+			places = append(places, place)
+		}
+	}
+	for _, group := range groups {
+		err := eachField(group, func(field int, wire int, v uint64, b []byte) error {
+			switch field {
+			case 1:
+				return readNode(b, block, add)
+			case 2:
+				return readDenseNodes(b, block, add)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return places, nil
+}
+
+func readNode(buf []byte, block primitiveBlock, add func(id, lat, lon int64, tags map[string]string)) error {
+	var id, lat, lon int64
+	var keys, vals []uint64
+	err := eachField(buf, func(field int, wire int, v uint64, b []byte) error {
+		var err error
+		switch field {
+		case 1:
+			id = zigzag(v)
+		case 2:
+			keys, err = packedVarints(wire, v, b, keys)
+		case 3:
+			vals, err = packedVarints(wire, v, b, vals)
+		case 8:
+			lat = zigzag(v)
+		case 9:
+			lon = zigzag(v)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	add(id, lat, lon, block.tags(keys, vals))
+	return nil
+}
+
+// readDenseNodes reads delta encoded nodes, whose tags are key and value
+// string indexes with a 0 after each node's tags.
+func readDenseNodes(buf []byte, block primitiveBlock, add func(id, lat, lon int64, tags map[string]string)) error {
+	var ids, lats, lons, keysVals []uint64
+	err := eachField(buf, func(field int, wire int, v uint64, b []byte) error {
+		var err error
+		switch field {
+		case 1:
+			ids, err = packedVarints(wire, v, b, ids)
+		case 8:
+			lats, err = packedVarints(wire, v, b, lats)
+		case 9:
+			lons, err = packedVarints(wire, v, b, lons)
+		case 10:
+			keysVals, err = packedVarints(wire, v, b, keysVals)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return errProtobuf
+	}
+
+	var id, lat, lon int64
+	kv := 0
+	for i := range ids {
+		id += zigzag(ids[i])
+		lat += zigzag(lats[i])
+		lon += zigzag(lons[i])
+		var keys, vals []uint64
+		for kv < len(keysVals) && keysVals[kv] != 0 {
+			if kv+1 >= len(keysVals) {
+				return errProtobuf
+			}
+			keys = append(keys, keysVals[kv])
+			vals = append(vals, keysVals[kv+1])
+			kv += 2
+		}
+		kv++
+		if len(keys) > 0 {
+			add(id, lat, lon, block.tags(keys, vals))
+		}
+	}
+	return nil
+}
+
+// eachField calls fn with each field of a protobuf message: varints in v and
+// length delimited fields in b. Fixed width fields are skipped.
+func eachField(buf []byte, fn func(field int, wire int, v uint64, b []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errProtobuf
+		}
+		buf = buf[n:]
+		field, wire := int(key>>3), int(key&7)
+		var v uint64
+		var b []byte
+		switch wire {
+		case wireVarint:
+			v, n = binary.Uvarint(buf)
+			if n <= 0 {
+				return errProtobuf
+			}
+			buf = buf[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < length {
+				return errProtobuf
+			}
+			b = buf[n : n+int(length)]
+			buf = buf[n+int(length):]
+		case wireFixed64:
+			if len(buf) < 8 {
+				return errProtobuf
+			}
+			buf = buf[8:]
+			continue
+		case wireFixed32:
+			if len(buf) < 4 {
+				return errProtobuf
+			}
+			buf = buf[4:]
+			continue
+		default:
+			return errProtobuf
+		}
+		if err := fn(field, wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packedVarints appends a repeated varint field, which may or may not be
+// packed.
+func packedVarints(wire int, v uint64, b []byte, values []uint64) ([]uint64, error) {
+	if wire == wireVarint {
+		return append(values, v), nil
+	}
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProtobuf
+		}
+		values = append(values, v)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
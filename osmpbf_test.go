@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pb builds protobuf messages for test fixtures.
+type pb struct{ bytes.Buffer }
+
+func (m *pb) varint(field int, v uint64) *pb {
+	m.uvarint(uint64(field)<<3 | wireVarint)
+	m.uvarint(v)
+	return m
+}
+
+func (m *pb) bytes(field int, b []byte) *pb {
+	m.uvarint(uint64(field)<<3 | wireBytes)
+	m.uvarint(uint64(len(b)))
+	m.Write(b)
+	return m
+}
+
+func (m *pb) packed(field int, values ...int64) *pb {
+	var packed pb
+	for _, v := range values {
+		packed.uvarint(uint64(v))
+	}
+	return m.bytes(field, packed.Bytes())
+}
+
+func (m *pb) uvarint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	m.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+func zigzagEncode(v int64) int64 {
+	return (v << 1) ^ (v >> 63)
+}
+
+func pbfBlob(blobType string, data []byte, compress bool) []byte {
+	var blob pb
+	if compress {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(data)
+		w.Close()
+		blob.varint(2, uint64(len(data))).bytes(3, z.Bytes())
+	} else {
+		blob.bytes(1, data)
+	}
+	var header pb
+	header.bytes(1, []byte(blobType)).varint(3, uint64(blob.Len()))
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, uint32(header.Len()))
+	out.Write(header.Bytes())
+	out.Write(blob.Bytes())
+	return out.Bytes()
+}
+
+func TestReadOSMPBF(t *testing.T) {
+	var stringTable pb
+	for _, s := range []string{"", "amenity", "restaurant", "name", "Cal Pep", "cafe", "Satan's Coffee Corner", "bench"} {
+		stringTable.bytes(1, []byte(s))
+	}
+
+	// Three dense nodes: Cal Pep, a bench and a cafe, at 41.3839,2.1826 and
+	// nearby, in units of the default granularity of 100 nanodegrees.
+	var dense pb
+	dense.packed(1, zigzagEncode(100), zigzagEncode(1), zigzagEncode(1)).
+		packed(8, zigzagEncode(413839000), zigzagEncode(-54000), zigzagEncode(100)).
+		packed(9, zigzagEncode(21826000), zigzagEncode(-108000), zigzagEncode(100)).
+		packed(10, 1, 2, 3, 4, 0, 1, 7, 0, 1, 5, 3, 6, 0)
+	var denseGroup pb
+	denseGroup.bytes(2, dense.Bytes())
+
+	// A plain node for a restaurant without a name, which is skipped.
+	var node pb
+	node.varint(1, uint64(zigzagEncode(200))).packed(2, 1).packed(3, 2).
+		varint(8, uint64(zigzagEncode(413782000))).varint(9, uint64(zigzagEncode(21718000)))
+	var nodeGroup pb
+	nodeGroup.bytes(1, node.Bytes())
+
+	var block pb
+	block.bytes(1, stringTable.Bytes()).bytes(2, denseGroup.Bytes()).bytes(2, nodeGroup.Bytes())
+
+	var file bytes.Buffer
+	file.Write(pbfBlob("OSMHeader", []byte{}, false))
+	file.Write(pbfBlob("OSMData", block.Bytes(), true))
+
+	places, err := ReadOSMPBF(&file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got []string
+	for _, p := range places {
+		got = append(got, p.ID+" "+p.Name+" "+strings.Join(p.Types, ","))
+	}
+	expected := []string{"osm:node/100 Cal Pep restaurant", "osm:node/102 Satan's Coffee Corner cafe"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if l := places[0].Location; l.Latitude < 41.38389 || l.Latitude > 41.38391 || l.Longitude < 2.18259 || l.Longitude > 2.18261 {
+		t.Errorf("expected Cal Pep at 41.3839,2.1826, got %v", l)
+	}
+}
+
+func TestReadOSMPBFErrors(t *testing.T) {
+	tests := [][]byte{
+		{0, 0, 0, 5, 1, 2},
+		pbfBlob("OSMData", []byte{0x0a, 0xff}, false),
+	}
+	for _, test := range tests {
+		if _, err := ReadOSMPBF(bytes.NewReader(test)); err == nil {
+			t.Errorf("expected error reading %v", test)
+		}
+	}
+}
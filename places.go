@@ -5,14 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	placesLimit           = 3
+	placesLimit = 3
+	// googleCandidates is how many places we ask Google for, so there are
+	// still enough once curated places they duplicate are merged out.
+	googleCandidates      = 2 * placesLimit
+	curatedLabel          = "Hungry Girl pick"
 	ErrInvalidCoordinates = "invalid coordinates"
+	errInvalidPriceLevel  = "price level must be between 1 and 4"
+	errInvalidDietary     = "unknown dietary flag"
+	// textSearchRadius is how far, in metres, text searches are biased
+	// around a location.
+	textSearchRadius = 1000
+	// areaSearchRadius is how far, in metres, searches for an area the user
+	// named are biased around where they last searched.
+	areaSearchRadius = 20000
+	// maxSearchPages is as many pages of results as Google will return.
+	maxSearchPages  = 3
+	providerTimeout = 10 * time.Second
+	// detailsConcurrency is how many places' details are fetched at once.
+	detailsConcurrency = 5
 )
 
+// nextPageDelay is how long to wait before asking for the next page of search
+// results.
+var nextPageDelay = 2 * time.Second
+
+var dietaryFlags = []string{"vegetarian", "vegan", "halal", "kosher", "gluten_free"}
+
 type Location struct {
 	Latitude  float64 `json:"lat"`
 	Longitude float64 `json:"lng"`
@@ -24,7 +51,43 @@ type Place struct {
 	Website  string   `json:"website"`
 	Rating   float64  `json:"rating"`
 	Geometry Geometry `json:"geometry"`
-	Location Location
+	Location Location `json:"location"`
+	// FeedbackScore is the sum of thumbs up (+1) and down (-1) from users.
+	FeedbackScore int `json:"-"`
+	// Curated is set for places recommended by Hungry Girl rather than Google.
+	Curated      bool     `json:"-"`
+	Status       string   `json:"status,omitempty"`
+	CuratorScore *float64 `json:"curator_score,omitempty"`
+	Types        []string `json:"types,omitempty"`
+	Cuisines     []string `json:"cuisines,omitempty"`
+	Dietary      []string `json:"dietary,omitempty"`
+	PriceLevel   int      `json:"price_level,omitempty"`
+	// Note is the curator's one-line summary, shown under the place's name.
+	Note string `json:"note,omitempty"`
+	// Blurb says why we like the place and is sent after its card along with
+	// the dish to order.
+	Blurb           string        `json:"blurb,omitempty"`
+	RecommendedDish string        `json:"recommended_dish,omitempty"`
+	OpeningHours    *OpeningHours `json:"opening_hours,omitempty"`
+	// UTCOffset is the place's offset from UTC in minutes, as reported by
+	// Google, used when no Timezone has been set.
+	UTCOffset      int       `json:"utc_offset,omitempty"`
+	Timezone       string    `json:"timezone,omitempty"`
+	HoursUpdatedAt time.Time `json:"-"`
+	// Closed and OpeningNote are set by markOpening.
+	Closed      bool         `json:"-"`
+	OpeningNote string       `json:"-"`
+	Photos      []PlacePhoto `json:"photos,omitempty"`
+	// ImageUrl is a photo of the place for its card, if we have one.
+	ImageUrl string `json:"-"`
+	// detailed is set once getDetails has filled the place in, so its
+	// details aren't fetched again before it's sent.
+	detailed bool
+}
+
+// PlacePhoto refers to a photo that can be fetched with PlacesProvider.Photo.
+type PlacePhoto struct {
+	Reference string `json:"photo_reference"`
 }
 
 type GooglePlacesClient struct {
@@ -37,7 +100,8 @@ type errorResponse struct {
 }
 
 type GooglePlacesSearchResponse struct {
-	Results []Place `json:"results"`
+	Results       []Place `json:"results"`
+	NextPageToken string  `json:"next_page_token,omitempty"`
 }
 
 type GooglePlacesDetailsResponse struct {
@@ -48,59 +112,232 @@ type Geometry struct {
 	Location Location `json:"location"`
 }
 
-func (l Location) GetPlacesFromGoogle(client GooglePlacesClient) ([]Place, error) {
-	url := fmt.Sprintf("%s/nearbysearch/json?location=%v,%v&radius=500&type=restaurant&opennow=true&key=%s", client.BaseURL, l.Latitude, l.Longitude, client.APIKey)
+// NearbySearch holds the optional parameters of a Google nearby search.
+type NearbySearch struct {
+	OpenNow bool
+	Limit   int
+	// Type is a Google place type, restaurant if unset.
+	Type string
+	// MinPrice and MaxPrice are Google price levels from 1 to 4; 0 means
+	// unbounded.
+	MinPrice int
+	MaxPrice int
+}
 
-	resp, err := getSuccessfulResponseFromGooglePlaces(url)
-	if err != nil {
-		return nil, err
+func (l Location) GetPlacesFromGoogle(provider PlacesProvider) ([]Place, error) {
+	return provider.Nearby(l, NearbySearch{OpenNow: true, Limit: placesLimit})
+}
+
+func (client GooglePlacesClient) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	placeType := search.Type
+	if placeType == "" {
+		placeType = defaultPlaceType
 	}
+	params := url.Values{}
+	params.Set("location", fmt.Sprintf("%v,%v", l.Latitude, l.Longitude))
+	params.Set("radius", "500")
+	params.Set("type", placeType)
+	setSearchFilters(params, search.OpenNow, search.MinPrice, search.MaxPrice)
+	return client.search("nearbysearch", params, search.Limit, 1)
+}
 
-	var g GooglePlacesSearchResponse
-	err = json.NewDecoder(resp.Body).Decode(&g)
-	if err != nil {
-		fmt.Println(err)
+// TextQuery holds the parameters of a Google text search.
+type TextQuery struct {
+	// Query is free text such as "best dumplings in Soho".
+	Query string
+	// Location biases results towards places within Radius metres of it.
+	Location *Location
+	Radius   int
+	// Type optionally restricts results to a Google place type.
+	Type     string
+	OpenNow  bool
+	MinPrice int
+	MaxPrice int
+	// Limit is how many places to return; 0 means all of the first page.
+	Limit int
+	// Pages is how many pages of results to fetch, up to maxSearchPages, if
+	// the first doesn't have Limit places. Google makes us wait for each
+	// further page, so they shouldn't be fetched while answering a message.
+	Pages int
+}
+
+// TextSearch finds places matching a free-text query such as a restaurant name
+// and area.
+func (client GooglePlacesClient) TextSearch(q TextQuery) ([]Place, error) {
+	params := url.Values{}
+	params.Set("query", q.Query)
+	if q.Location != nil {
+		params.Set("location", fmt.Sprintf("%v,%v", q.Location.Latitude, q.Location.Longitude))
+		radius := q.Radius
+		if radius == 0 {
+			radius = textSearchRadius
+		}
+		params.Set("radius", strconv.Itoa(radius))
+	}
+	if q.Type != "" {
+		params.Set("type", q.Type)
+	}
+	setSearchFilters(params, q.OpenNow, q.MinPrice, q.MaxPrice)
+	return client.search("textsearch", params, q.Limit, q.Pages)
+}
+
+// searchPages is how many pages a search asking for pages may fetch: at least
+// one and at most maxSearchPages.
+func searchPages(pages int) int {
+	if pages < 1 {
+		return 1
+	}
+	if pages > maxSearchPages {
+		return maxSearchPages
+	}
+	return pages
+}
+
+func setSearchFilters(params url.Values, openNow bool, minPrice, maxPrice int) {
+	if openNow {
+		params.Set("opennow", "true")
 	}
+	if minPrice > 0 {
+		params.Set("minprice", strconv.Itoa(minPrice))
+	}
+	if maxPrice > 0 {
+		params.Set("maxprice", strconv.Itoa(maxPrice))
+	}
+}
 
+// search runs a nearby or text search, following next_page_token for up to
+// pages pages until limit places are found. Google takes a moment to make the
+// next page available.
+func (client GooglePlacesClient) search(endpoint string, params url.Values, limit, pages int) ([]Place, error) {
+	params.Set("key", client.APIKey)
 	var p []Place
-	numPlaces := 0
-	for _, result := range g.Results {
-		if numPlaces < placesLimit {
+	for page := 0; page < searchPages(pages); page++ {
+		resp, err := getSuccessfulResponseFromGooglePlaces(fmt.Sprintf("%s/%s/json?%s", client.BaseURL, endpoint, params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		var g GooglePlacesSearchResponse
+		err = json.NewDecoder(resp.Body).Decode(&g)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range g.Results {
+			if limit > 0 && len(p) == limit {
+				return p, nil
+			}
 			p = append(p, Place{
-				Name: result.Name,
-				ID:   result.ID,
+				Name:       result.Name,
+				ID:         result.ID,
+				Location:   result.Geometry.Location,
+				PriceLevel: result.PriceLevel,
+				Types:      result.Types,
+				Photos:     result.Photos,
 			})
-			numPlaces++
 		}
+		if page+1 == pages || limit == 0 || len(p) >= limit || g.NextPageToken == "" {
+			break
+		}
+		time.Sleep(nextPageDelay)
+		params = url.Values{"pagetoken": {g.NextPageToken}, "key": {client.APIKey}}
 	}
 	return p, nil
 }
 
-func (p *Place) GetDetails(client GooglePlacesClient) error {
-	url := fmt.Sprintf("%s/details/json?placeid=%s&key=%s", client.BaseURL, p.ID, client.APIKey)
-	resp, err := getSuccessfulResponseFromGooglePlaces(url)
+// GetDetails fills in a place's website, rating, location and opening hours.
+func (p *Place) GetDetails(provider PlacesProvider) error {
+	details, err := provider.Details(p.ID)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
+	if p.Name == "" {
+		p.Name = details.Name
+	}
+	p.Website = details.Website
+	p.Rating = details.Rating
+	p.Location = details.Location
+	p.OpeningHours = details.OpeningHours
+	p.UTCOffset = details.UTCOffset
+	if p.PriceLevel == 0 {
+		p.PriceLevel = details.PriceLevel
+	}
+	if len(p.Photos) == 0 {
+		p.Photos = details.Photos
+	}
+	return nil
+}
+
+// getDetails calls GetDetails on the places at indexes, detailsConcurrency at
+// a time, returning the errors in the same order as indexes.
+func getDetails(provider PlacesProvider, places []Place, indexes []int) []error {
+	errs := make([]error, len(indexes))
+	sem := make(chan struct{}, detailsConcurrency)
+	var wg sync.WaitGroup
+	for n, i := range indexes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n, i int) {
+			defer wg.Done()
+			errs[n] = places[i].GetDetails(provider)
+			places[i].detailed = errs[n] == nil
+			<-sem
+		}(n, i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (client GooglePlacesClient) Details(placeID string) (Place, error) {
+	detailsUrl := fmt.Sprintf("%s/details/json?placeid=%s&key=%s", client.BaseURL, url.QueryEscape(placeID), client.APIKey)
+	resp, err := getSuccessfulResponseFromGooglePlaces(detailsUrl)
+	if err != nil {
+		return Place{}, err
+	}
+	defer resp.Body.Close()
+
 	var g GooglePlacesDetailsResponse
 	err = json.NewDecoder(resp.Body).Decode(&g)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return Place{}, err
 	}
+	place := g.Place
+	place.Location = place.Geometry.Location
+	place.Geometry = Geometry{}
+	return place, nil
+}
 
-	p.Website = g.Place.Website
-	p.Rating = g.Place.Rating
-	p.Location = g.Place.Geometry.Location
-	return nil
+// Photo returns where Google redirects a photo request to, which can be
+// shared without giving away our API key.
+func (client GooglePlacesClient) Photo(reference string, maxWidth int) (string, error) {
+	params := url.Values{}
+	params.Set("maxwidth", strconv.Itoa(maxWidth))
+	params.Set("photo_reference", reference)
+	params.Set("key", client.APIKey)
+	noRedirects := &http.Client{
+		Timeout: providerTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := noRedirects.Get(fmt.Sprintf("%s/photo?%s", client.BaseURL, params.Encode()))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || location == "" {
+		return "", errors.New("error retrieving Google Places photo")
+	}
+	return location, nil
 }
 
 func NewGooglePlacesClient(c Config) GooglePlacesClient {
 	client := GooglePlacesClient{
 		BaseURL: "https://maps.googleapis.com/maps/api/place",
-		APIKey:  os.Getenv("GOOGLE_PLACES_API_KEY"),
+		APIKey:  c.GooglePlacesAPIKey,
 	}
 	if c.APIBaseURL != "" {
 		client.BaseURL = c.APIBaseURL
@@ -109,6 +346,9 @@ func NewGooglePlacesClient(c Config) GooglePlacesClient {
 }
 
 func NewLocation(latitude, longitude float64) (*Location, error) {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return nil, errors.New(ErrInvalidCoordinates)
+	}
 	l := Location{
 		Latitude:  latitude,
 		Longitude: longitude,
@@ -117,8 +357,8 @@ func NewLocation(latitude, longitude float64) (*Location, error) {
 	return &l, nil
 }
 
-func getSuccessfulResponseFromGooglePlaces(url string) (*http.Response, error) {
-	resp, err := http.Get(url)
+func getSuccessfulResponseFromGooglePlaces(requestUrl string) (*http.Response, error) {
+	resp, err := http.Get(requestUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -128,10 +368,95 @@ func getSuccessfulResponseFromGooglePlaces(url string) (*http.Response, error) {
 	return resp, nil
 }
 
+// MergePlaces returns the curated places followed by Google places not already
+// curated, up to limit places in total.
+func MergePlaces(curated, google []Place, limit int) []Place {
+	var merged []Place
+	seen := make(map[string]bool)
+	for _, places := range [][]Place{curated, google} {
+		for _, place := range places {
+			if len(merged) == limit {
+				return merged
+			}
+			if seen[place.ID] {
+				continue
+			}
+			seen[place.ID] = true
+			merged = append(merged, place)
+		}
+	}
+	return merged
+}
+
+func (p *Place) Label() string {
+	if p.Curated {
+		return curatedLabel
+	}
+	return ""
+}
+
+// normaliseTags lowercases cuisine and dietary tags and checks the dietary
+// flags and price level are ones we know about.
+func (p *Place) normaliseTags() error {
+	for i, c := range p.Cuisines {
+		p.Cuisines[i] = strings.ToLower(strings.TrimSpace(c))
+	}
+	for i, d := range p.Dietary {
+		p.Dietary[i] = strings.ToLower(strings.TrimSpace(d))
+		if !isDietaryFlag(p.Dietary[i]) {
+			return fmt.Errorf("%s %q", errInvalidDietary, d)
+		}
+	}
+	if p.PriceLevel < 0 || p.PriceLevel > 4 {
+		return errors.New(errInvalidPriceLevel)
+	}
+	return nil
+}
+
+func isDietaryFlag(flag string) bool {
+	for _, f := range dietaryFlags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Subtitle is shown under the name on a place's card.
+func (p *Place) Subtitle() string {
+	subtitle := p.Label()
+	if price := p.PriceLabel(); price != "" {
+		if subtitle == "" {
+			subtitle = price
+		} else {
+			subtitle = fmt.Sprintf("%s · %s", price, subtitle)
+		}
+	}
+	if p.Note != "" {
+		if subtitle == "" {
+			subtitle = p.Note
+		} else {
+			subtitle = fmt.Sprintf("%s: %s", subtitle, p.Note)
+		}
+	}
+	if p.OpeningNote != "" {
+		if subtitle == "" {
+			return p.OpeningNote
+		}
+		return fmt.Sprintf("%s\n%s", p.OpeningNote, subtitle)
+	}
+	return subtitle
+}
+
 func (p *Place) StaticMapUrl() string {
 	return fmt.Sprintf("https://maps.googleapis.com/maps/api/staticmap?markers=color:red|label:B|%v,%v&size=360x360&zoom=13", p.Location.Latitude, p.Location.Longitude)
 }
 
+// LinkMapUrl opens the place in Google Maps, by its ID if it came from Google
+// or by its location otherwise.
 func (p *Place) LinkMapUrl() string {
+	if providerName(p.ID) != providerGoogle {
+		return fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%v,%v", p.Location.Latitude, p.Location.Longitude)
+	}
 	return fmt.Sprintf("https://www.google.com/maps/place/?q=place_id:%s", p.ID)
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+
+	errInvalidBoundingBox = "bbox must be minLng,minLat,maxLng,maxLat"
+	errMissingPlaceFields = "place_id and name are required"
+)
+
+// PlaceStore is the storage the admin API needs; PlaceRepository implements
+// it against Postgres.
+type PlaceStore interface {
+	Get(placeID string) (Place, error)
+	List(q PlaceQuery) ([]Place, int, error)
+	Create(place Place, actor string) error
+	Update(place Place, actor string) error
+	Delete(placeID, actor string) error
+}
+
+type PlacesPage struct {
+	Places  []Place `json:"places"`
+	Total   int     `json:"total"`
+	Page    int     `json:"page"`
+	PerPage int     `json:"per_page"`
+}
+
+// PlacesAPI serves CRUD for curated places:
+//
+//	GET    /admin/api/places?name=&bbox=&status=&page=&per_page=
+//	POST   /admin/api/places
+//	GET    /admin/api/places/{id}
+//	PUT    /admin/api/places/{id}
+//	DELETE /admin/api/places/{id}
+type PlacesAPI struct {
+	Store PlaceStore
+}
+
+func (api PlacesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	placeID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/places"), "/")
+
+	switch {
+	case placeID == "" && r.Method == "GET":
+		api.list(w, r)
+	case placeID == "" && r.Method == "POST":
+		place, err := decodePlace(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if place.Status == "" {
+			place.Status = StatusApproved
+		}
+		if err := api.Store.Create(place, adminUser(r)); err != nil {
+			writePlacesAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, place)
+	case placeID != "" && r.Method == "GET":
+		place, err := api.Store.Get(placeID)
+		if err != nil {
+			writePlacesAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, place)
+	case placeID != "" && r.Method == "PUT":
+		place, err := decodePlace(r)
+		if err == nil && place.ID != placeID {
+			err = errors.New("place_id does not match url")
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		// Leaving the status out keeps it, so an edit can't approve a
+		// submission by accident.
+		if place.Status == "" {
+			existing, err := api.Store.Get(placeID)
+			if err != nil {
+				writePlacesAPIError(w, err)
+				return
+			}
+			place.Status = existing.Status
+		}
+		if err := api.Store.Update(place, adminUser(r)); err != nil {
+			writePlacesAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, place)
+	case placeID != "" && r.Method == "DELETE":
+		if err := api.Store.Delete(placeID, adminUser(r)); err != nil {
+			writePlacesAPIError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (api PlacesAPI) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, perPage := pageParams(query.Get("page"), query.Get("per_page"))
+	q := PlaceQuery{
+		Name:   query.Get("name"),
+		Status: query.Get("status"),
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	}
+	if q.Status != "" && !validStatus(q.Status) {
+		writeJSONError(w, http.StatusBadRequest, errors.New(errInvalidStatus))
+		return
+	}
+	if bbox := query.Get("bbox"); bbox != "" {
+		bounds, err := parseBoundingBox(bbox)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		q.Bounds = bounds
+	}
+
+	places, total, err := api.Store.List(q)
+	if err != nil {
+		writePlacesAPIError(w, err)
+		return
+	}
+	if places == nil {
+		places = []Place{}
+	}
+	writeJSON(w, http.StatusOK, PlacesPage{
+		Places:  places,
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+func pageParams(pageParam, perPageParam string) (page, perPage int) {
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err = strconv.Atoi(perPageParam)
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
+
+func parseBoundingBox(bbox string) (*BoundingBox, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return nil, errors.New(errInvalidBoundingBox)
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errors.New(errInvalidBoundingBox)
+		}
+		coords[i] = f
+	}
+	sw, err := NewLocation(coords[1], coords[0])
+	if err != nil {
+		return nil, err
+	}
+	ne, err := NewLocation(coords[3], coords[2])
+	if err != nil {
+		return nil, err
+	}
+	return &BoundingBox{SouthWest: *sw, NorthEast: *ne}, nil
+}
+
+// decodePlace reads and validates a place from the request body. Its status
+// is left empty if none was sent.
+func decodePlace(r *http.Request) (Place, error) {
+	var place Place
+	if err := json.NewDecoder(r.Body).Decode(&place); err != nil {
+		return Place{}, err
+	}
+	if place.ID == "" || strings.TrimSpace(place.Name) == "" {
+		return Place{}, errors.New(errMissingPlaceFields)
+	}
+	if _, err := NewLocation(place.Location.Latitude, place.Location.Longitude); err != nil {
+		return Place{}, err
+	}
+	if place.Status != "" && !validStatus(place.Status) {
+		return Place{}, errors.New(errInvalidStatus)
+	}
+	if place.CuratorScore != nil && !validCuratorScore(*place.CuratorScore) {
+		return Place{}, errors.New(errInvalidCuratorScore)
+	}
+	if err := place.normaliseTags(); err != nil {
+		return Place{}, err
+	}
+	place.Curated = true
+	return place, nil
+}
+
+func writePlacesAPIError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case errPlaceNotFound:
+		writeJSONError(w, http.StatusNotFound, err)
+	case errPlaceExists:
+		writeJSONError(w, http.StatusConflict, err)
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err)
+	}
+}
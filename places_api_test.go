@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memoryPlaceStore is an in-memory PlaceStore for testing the admin API
+// without Postgres.
+type memoryPlaceStore struct {
+	places    map[string]Place
+	lastQuery PlaceQuery
+	actors    []string
+}
+
+func newMemoryPlaceStore(places ...Place) *memoryPlaceStore {
+	s := &memoryPlaceStore{places: make(map[string]Place)}
+	for _, p := range places {
+		s.places[p.ID] = p
+	}
+	return s
+}
+
+func (s *memoryPlaceStore) Get(placeID string) (Place, error) {
+	p, ok := s.places[placeID]
+	if !ok {
+		return Place{}, errors.New(errPlaceNotFound)
+	}
+	return p, nil
+}
+
+func (s *memoryPlaceStore) List(q PlaceQuery) ([]Place, int, error) {
+	s.lastQuery = q
+	var matches []Place
+	for _, p := range s.places {
+		if !strings.Contains(strings.ToLower(p.Name), strings.ToLower(q.Name)) {
+			continue
+		}
+		if q.Status != "" && p.Status != q.Status {
+			continue
+		}
+		if q.Bounds != nil && !inBounds(*q.Bounds, p.Location) {
+			continue
+		}
+		matches = append(matches, p)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+	total := len(matches)
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+	end := q.Offset + q.Limit
+	if end > total {
+		end = total
+	}
+	return matches[q.Offset:end], total, nil
+}
+
+func inBounds(b BoundingBox, l Location) bool {
+	return l.Latitude >= b.SouthWest.Latitude && l.Latitude <= b.NorthEast.Latitude &&
+		l.Longitude >= b.SouthWest.Longitude && l.Longitude <= b.NorthEast.Longitude
+}
+
+func (s *memoryPlaceStore) Create(p Place, actor string) error {
+	if _, ok := s.places[p.ID]; ok {
+		return errors.New(errPlaceExists)
+	}
+	s.places[p.ID] = p
+	s.actors = append(s.actors, actor)
+	return nil
+}
+
+func (s *memoryPlaceStore) Update(p Place, actor string) error {
+	if _, ok := s.places[p.ID]; !ok {
+		return errors.New(errPlaceNotFound)
+	}
+	s.places[p.ID] = p
+	s.actors = append(s.actors, actor)
+	return nil
+}
+
+func (s *memoryPlaceStore) Delete(placeID, actor string) error {
+	if _, ok := s.places[placeID]; !ok {
+		return errors.New(errPlaceNotFound)
+	}
+	delete(s.places, placeID)
+	s.actors = append(s.actors, actor)
+	return nil
+}
+
+func TestPlacesAPIList(t *testing.T) {
+	barcelona := Location{Latitude: 41.38, Longitude: 2.18}
+	store := newMemoryPlaceStore(
+		Place{ID: "a", Name: "Bar Marsella", Status: StatusApproved, Location: barcelona},
+		Place{ID: "b", Name: "Bar del Pla", Status: StatusApproved, Location: barcelona},
+		Place{ID: "c", Name: "Cal Pep", Status: StatusApproved, Location: barcelona},
+		Place{ID: "d", Name: "Bar Brutal", Status: StatusRejected, Location: barcelona},
+		Place{ID: "e", Name: "Bar Italia", Status: StatusApproved, Location: Location{Latitude: 51.51, Longitude: -0.13}},
+	)
+	api := PlacesAPI{Store: store}
+
+	req := httptest.NewRequest("GET", "/admin/api/places?name=bar&status=approved&page=2&per_page=1&bbox=2.16,41.37,2.19,41.39", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var got PlacesPage
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error decoding response: %s", err)
+	}
+	if got.Total != 2 || got.Page != 2 || got.PerPage != 1 {
+		t.Errorf("expected page 2 of 2 with 1 per page, got %+v", got)
+	}
+	if len(got.Places) != 1 || got.Places[0].ID != "b" {
+		t.Errorf("expected Bar del Pla on page 2, got %v", got.Places)
+	}
+
+	expectedBounds := &BoundingBox{
+		SouthWest: Location{Latitude: 41.37, Longitude: 2.16},
+		NorthEast: Location{Latitude: 41.39, Longitude: 2.19},
+	}
+	if !reflect.DeepEqual(store.lastQuery.Bounds, expectedBounds) {
+		t.Errorf("expected bounds %v, got %v", expectedBounds, store.lastQuery.Bounds)
+	}
+}
+
+func TestPlacesAPIInvalidRequests(t *testing.T) {
+	api := PlacesAPI{Store: newMemoryPlaceStore(Place{ID: "a", Name: "Bar Marsella"})}
+
+	tests := []struct {
+		Method string
+		Path   string
+		Body   string
+		Status int
+	}{
+		{Method: "GET", Path: "/admin/api/places?bbox=1,2,3", Status: http.StatusBadRequest},
+		{Method: "GET", Path: "/admin/api/places/missing", Status: http.StatusNotFound},
+		{Method: "POST", Path: "/admin/api/places", Body: `{"name": "No ID"}`, Status: http.StatusBadRequest},
+		{Method: "POST", Path: "/admin/api/places", Body: `{"place_id": "b", "name": "Cal Pep", "location": {"lat": 91, "lng": 0}}`, Status: http.StatusBadRequest},
+		{Method: "POST", Path: "/admin/api/places", Body: `{"place_id": "b", "name": "Cal Pep", "curator_score": 7.5}`, Status: http.StatusBadRequest},
+		{Method: "POST", Path: "/admin/api/places", Body: `{"place_id": "a", "name": "Bar Marsella"}`, Status: http.StatusConflict},
+		{Method: "PUT", Path: "/admin/api/places/a", Body: `{"place_id": "a", "name": "Bar Marsella", "curator_score": -1}`, Status: http.StatusBadRequest},
+		{Method: "PUT", Path: "/admin/api/places/missing", Body: `{"place_id": "missing", "name": "Cal Pep"}`, Status: http.StatusNotFound},
+		{Method: "PUT", Path: "/admin/api/places/a", Body: `{"place_id": "b", "name": "Cal Pep"}`, Status: http.StatusBadRequest},
+		{Method: "DELETE", Path: "/admin/api/places/missing", Status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.Method, test.Path, strings.NewReader(test.Body))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		if w.Code != test.Status {
+			t.Errorf("expected status %d for %s %s, got %d", test.Status, test.Method, test.Path, w.Code)
+		}
+	}
+}
+
+func TestPlacesAPICreateUpdateDelete(t *testing.T) {
+	store := newMemoryPlaceStore()
+	app := newApp(Config{AdminUsers: "nicola:s3cret"}, nil, stubProvider{}, &fakeSender{})
+	handler := app.adminAuth(PlacesAPI{Store: store}.ServeHTTP)
+
+	requests := []struct {
+		Method string
+		Path   string
+		Body   string
+		Status int
+	}{
+		{Method: "POST", Path: "/admin/api/places", Body: `{"place_id": "a", "name": "Bar Marsella", "location": {"lat": 41.378, "lng": 2.171}}`, Status: http.StatusCreated},
+		{Method: "PUT", Path: "/admin/api/places/a", Body: `{"place_id": "a", "name": "Bar Marsella", "status": "rejected", "location": {"lat": 41.378, "lng": 2.171}}`, Status: http.StatusOK},
+		{Method: "PUT", Path: "/admin/api/places/a", Body: `{"place_id": "a", "name": "Bar Marsella Bar", "location": {"lat": 41.378, "lng": 2.171}}`, Status: http.StatusOK},
+		{Method: "GET", Path: "/admin/api/places/a", Status: http.StatusOK},
+		{Method: "DELETE", Path: "/admin/api/places/a", Status: http.StatusNoContent},
+	}
+
+	for _, r := range requests {
+		req := httptest.NewRequest(r.Method, r.Path, strings.NewReader(r.Body))
+		req.SetBasicAuth("nicola", "s3cret")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != r.Status {
+			t.Fatalf("expected status %d for %s %s, got %d: %s", r.Status, r.Method, r.Path, w.Code, w.Body)
+		}
+		if r.Method == "PUT" && store.places["a"].Status != StatusRejected {
+			t.Errorf("expected place to be rejected, got %s", store.places["a"].Status)
+		}
+	}
+
+	if len(store.places) != 0 {
+		t.Errorf("expected place to be deleted, got %v", store.places)
+	}
+	expectedActors := []string{"nicola", "nicola", "nicola", "nicola"}
+	if !reflect.DeepEqual(store.actors, expectedActors) {
+		t.Errorf("expected changes by %v, got %v", expectedActors, store.actors)
+	}
+}
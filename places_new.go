@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+const (
+	// newNearbyMaxResults is the most places a Places API (New) nearby
+	// search returns; it has no further pages.
+	newNearbyMaxResults = 20
+
+	newSearchFieldMask = "places.id,places.displayName,places.location,places.priceLevel,places.types," +
+		"places.photos,places.currentOpeningHours.openNow"
+	newDetailsFieldMask = "id,displayName,websiteUri,rating,location,priceLevel,types,photos," +
+		"regularOpeningHours.periods,utcOffsetMinutes"
+)
+
+// newPriceLevels maps the Places API (New) price levels to the legacy 0 to 4.
+var newPriceLevels = map[string]int{
+	"PRICE_LEVEL_FREE":           0,
+	"PRICE_LEVEL_INEXPENSIVE":    1,
+	"PRICE_LEVEL_MODERATE":       2,
+	"PRICE_LEVEL_EXPENSIVE":      3,
+	"PRICE_LEVEL_VERY_EXPENSIVE": 4,
+}
+
+// GooglePlacesNewClient talks to the Places API (New), which authenticates
+// with an X-Goog-Api-Key header and only returns the fields asked for in
+// X-Goog-FieldMask.
+type GooglePlacesNewClient struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+}
+
+type newPlace struct {
+	ID          string `json:"id"`
+	DisplayName struct {
+		Text string `json:"text"`
+	} `json:"displayName"`
+	WebsiteURI string  `json:"websiteUri"`
+	Rating     float64 `json:"rating"`
+	Location   struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"location"`
+	PriceLevel          string   `json:"priceLevel"`
+	Types               []string `json:"types"`
+	CurrentOpeningHours *struct {
+		OpenNow bool `json:"openNow"`
+	} `json:"currentOpeningHours"`
+	RegularOpeningHours *struct {
+		Periods []struct {
+			Open  newDayTime  `json:"open"`
+			Close *newDayTime `json:"close"`
+		} `json:"periods"`
+	} `json:"regularOpeningHours"`
+	UTCOffsetMinutes int `json:"utcOffsetMinutes"`
+	Photos           []struct {
+		Name string `json:"name"`
+	} `json:"photos"`
+}
+
+type newDayTime struct {
+	Day    int `json:"day"`
+	Hour   int `json:"hour"`
+	Minute int `json:"minute"`
+}
+
+type newSearchResponse struct {
+	Places        []newPlace `json:"places"`
+	NextPageToken string     `json:"nextPageToken"`
+}
+
+type newErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type newCircle struct {
+	Center struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"center"`
+	Radius float64 `json:"radius"`
+}
+
+type newArea struct {
+	Circle newCircle `json:"circle"`
+}
+
+func NewGooglePlacesNewClient(c Config) GooglePlacesNewClient {
+	client := GooglePlacesNewClient{
+		BaseURL: "https://places.googleapis.com/v1",
+		APIKey:  c.GooglePlacesAPIKey,
+		Client:  &http.Client{Timeout: providerTimeout},
+	}
+	if c.APIBaseURL != "" {
+		client.BaseURL = c.APIBaseURL
+	}
+	return client
+}
+
+// Nearby searches within 500m. The Places API (New) can't filter nearby
+// searches by opening hours or price, so that's done here.
+func (client GooglePlacesNewClient) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	placeType := search.Type
+	if placeType == "" {
+		placeType = defaultPlaceType
+	}
+	body := map[string]interface{}{
+		"includedTypes":       []string{placeType},
+		"maxResultCount":      newNearbyMaxResults,
+		"locationRestriction": newCircleArea(l, 500),
+	}
+	var resp newSearchResponse
+	if err := client.do("POST", "/places:searchNearby", newSearchFieldMask, body, &resp); err != nil {
+		return nil, err
+	}
+
+	var p []Place
+	for _, result := range resp.Places {
+		if search.Limit > 0 && len(p) == search.Limit {
+			break
+		}
+		place := result.place()
+		if search.OpenNow && result.CurrentOpeningHours != nil && !result.CurrentOpeningHours.OpenNow {
+			continue
+		}
+		if (search.MinPrice > 0 && place.PriceLevel < search.MinPrice) ||
+			(search.MaxPrice > 0 && place.PriceLevel > search.MaxPrice) {
+			continue
+		}
+		p = append(p, place)
+	}
+	return p, nil
+}
+
+func (client GooglePlacesNewClient) TextSearch(q TextQuery) ([]Place, error) {
+	body := map[string]interface{}{"textQuery": q.Query}
+	if q.Location != nil {
+		radius := q.Radius
+		if radius == 0 {
+			radius = textSearchRadius
+		}
+		body["locationBias"] = newCircleArea(*q.Location, radius)
+	}
+	if q.Type != "" {
+		body["includedType"] = q.Type
+	}
+	if q.OpenNow {
+		body["openNow"] = true
+	}
+	if levels := newPriceLevelRange(q.MinPrice, q.MaxPrice); len(levels) > 0 {
+		body["priceLevels"] = levels
+	}
+
+	var p []Place
+	for page := 0; page < searchPages(q.Pages); page++ {
+		var resp newSearchResponse
+		if err := client.do("POST", "/places:searchText", newSearchFieldMask+",nextPageToken", body, &resp); err != nil {
+			return nil, err
+		}
+		for _, result := range resp.Places {
+			if q.Limit > 0 && len(p) == q.Limit {
+				return p, nil
+			}
+			p = append(p, result.place())
+		}
+		if q.Limit == 0 || len(p) >= q.Limit || resp.NextPageToken == "" {
+			break
+		}
+		body["pageToken"] = resp.NextPageToken
+	}
+	return p, nil
+}
+
+func (client GooglePlacesNewClient) Details(placeID string) (Place, error) {
+	var result newPlace
+	if err := client.do("GET", "/places/"+url.PathEscape(placeID), newDetailsFieldMask, nil, &result); err != nil {
+		return Place{}, err
+	}
+	return result.place(), nil
+}
+
+// Photo asks for the photo's URL rather than being redirected to it.
+func (client GooglePlacesNewClient) Photo(reference string, maxWidth int) (string, error) {
+	path := fmt.Sprintf("/%s/media?maxWidthPx=%d&skipHttpRedirect=true", reference, maxWidth)
+	var photo struct {
+		PhotoURI string `json:"photoUri"`
+	}
+	if err := client.do("GET", path, "", nil, &photo); err != nil {
+		return "", err
+	}
+	if photo.PhotoURI == "" {
+		return "", errors.New("error retrieving Google Places photo")
+	}
+	return photo.PhotoURI, nil
+}
+
+func (client GooglePlacesNewClient) do(method, path, fieldMask string, body, v interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, client.BaseURL+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Api-Key", client.APIKey)
+	if fieldMask != "" {
+		req.Header.Set("X-Goog-FieldMask", fieldMask)
+	}
+
+	httpClient := client.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e newErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("error retrieving Google Places response: %s", e.Error.Message)
+		}
+		return errors.New("error retrieving Google Places response")
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (result newPlace) place() Place {
+	place := Place{
+		ID:         result.ID,
+		Name:       result.DisplayName.Text,
+		Website:    result.WebsiteURI,
+		Rating:     result.Rating,
+		Location:   Location{Latitude: result.Location.Latitude, Longitude: result.Location.Longitude},
+		PriceLevel: newPriceLevels[result.PriceLevel],
+		Types:      result.Types,
+		UTCOffset:  result.UTCOffsetMinutes,
+	}
+	for _, photo := range result.Photos {
+		place.Photos = append(place.Photos, PlacePhoto{Reference: photo.Name})
+	}
+	if result.RegularOpeningHours != nil {
+		place.OpeningHours = &OpeningHours{}
+		for _, period := range result.RegularOpeningHours.Periods {
+			p := OpeningPeriod{Open: period.Open.dayTime()}
+			if period.Close != nil {
+				close := period.Close.dayTime()
+				p.Close = &close
+			}
+			place.OpeningHours.Periods = append(place.OpeningHours.Periods, p)
+		}
+	}
+	return place
+}
+
+func (d newDayTime) dayTime() DayTime {
+	return DayTime{Day: d.Day, Time: fmt.Sprintf("%02d%02d", d.Hour, d.Minute)}
+}
+
+func newCircleArea(l Location, radius int) newArea {
+	var area newArea
+	area.Circle.Center.Latitude = l.Latitude
+	area.Circle.Center.Longitude = l.Longitude
+	area.Circle.Radius = float64(radius)
+	return area
+}
+
+// newPriceLevelRange lists the Places API (New) price levels between min and
+// max, or nothing if neither is set.
+func newPriceLevelRange(min, max int) []string {
+	if min == 0 && max == 0 {
+		return nil
+	}
+	if max == 0 {
+		max = 4
+	}
+	var levels []string
+	for name, level := range newPriceLevels {
+		if level >= min && level <= max && level > 0 {
+			levels = append(levels, name)
+		}
+	}
+	sort.Strings(levels)
+	return levels
+}
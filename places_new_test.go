@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const newNearbyFixture = `{
+  "places": [
+    {
+      "id": "ChIJ3bKbZvmipBIR",
+      "displayName": {"text": "Bar Marsella", "languageCode": "es"},
+      "location": {"latitude": 41.3782, "longitude": 2.1718},
+      "priceLevel": "PRICE_LEVEL_INEXPENSIVE",
+      "types": ["bar", "restaurant"],
+      "photos": [{"name": "places/ChIJ3bKbZvmipBIR/photos/AUc7tXX"}],
+      "currentOpeningHours": {"openNow": true}
+    },
+    {
+      "id": "ChIJu2Dn1v2ipBIR",
+      "displayName": {"text": "Cal Pep"},
+      "location": {"latitude": 41.3839, "longitude": 2.1826},
+      "priceLevel": "PRICE_LEVEL_EXPENSIVE",
+      "currentOpeningHours": {"openNow": true}
+    },
+    {
+      "id": "ChIJclosed",
+      "displayName": {"text": "El Xampanyet"},
+      "currentOpeningHours": {"openNow": false}
+    }
+  ]
+}`
+
+const newDetailsFixture = `{
+  "id": "ChIJ3bKbZvmipBIR",
+  "displayName": {"text": "Bar Marsella"},
+  "websiteUri": "http://www.example.com",
+  "rating": 4.3,
+  "location": {"latitude": 41.3782, "longitude": 2.1718},
+  "priceLevel": "PRICE_LEVEL_INEXPENSIVE",
+  "regularOpeningHours": {
+    "periods": [{"open": {"day": 1, "hour": 22, "minute": 0}, "close": {"day": 2, "hour": 2, "minute": 30}}]
+  },
+  "utcOffsetMinutes": 120
+}`
+
+func newPlacesServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, GooglePlacesNewClient) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Goog-Api-Key"); got != "key" {
+			t.Errorf("expected api key header, got %q", got)
+		}
+		handler(w, r)
+	}))
+	return server, GooglePlacesNewClient{BaseURL: server.URL, APIKey: "key"}
+}
+
+func TestNewClientNearby(t *testing.T) {
+	server, client := newPlacesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/places:searchNearby" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if mask := r.Header.Get("X-Goog-FieldMask"); !strings.Contains(mask, "places.displayName") {
+			t.Errorf("expected a field mask, got %q", mask)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"includedTypes":["cafe"]`) {
+			t.Errorf("expected cafes to be searched for, got %s", body)
+		}
+		w.Write([]byte(newNearbyFixture))
+	})
+	defer server.Close()
+
+	places, err := client.Nearby(Location{Latitude: 41.38, Longitude: 2.17}, NearbySearch{OpenNow: true, Limit: 3, Type: "cafe", MaxPrice: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []Place{{
+		ID:         "ChIJ3bKbZvmipBIR",
+		Name:       "Bar Marsella",
+		Location:   Location{Latitude: 41.3782, Longitude: 2.1718},
+		PriceLevel: 1,
+		Types:      []string{"bar", "restaurant"},
+		Photos:     []PlacePhoto{{Reference: "places/ChIJ3bKbZvmipBIR/photos/AUc7tXX"}},
+	}}
+	if !reflect.DeepEqual(expected, places) {
+		t.Errorf("expected %v, got %v", expected, places)
+	}
+}
+
+func TestNewClientTextSearch(t *testing.T) {
+	var bodies []map[string]interface{}
+	server, client := newPlacesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if body["pageToken"] == nil {
+			w.Write([]byte(`{"places": [{"id": "a"}, {"id": "b"}], "nextPageToken": "page2"}`))
+			return
+		}
+		w.Write([]byte(`{"places": [{"id": "c"}]}`))
+	})
+	defer server.Close()
+
+	places, err := client.TextSearch(TextQuery{
+		Query:    "best dumplings",
+		Location: &Location{Latitude: 51.5136, Longitude: -0.1365},
+		MaxPrice: 2,
+		Limit:    5,
+		Pages:    maxSearchPages,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(places) != 3 || len(bodies) != 2 {
+		t.Fatalf("expected three places from two pages, got %v from %d", places, len(bodies))
+	}
+	first := bodies[0]
+	if first["textQuery"] != "best dumplings" || first["locationBias"] == nil {
+		t.Errorf("unexpected search %v", first)
+	}
+	levels := []interface{}{"PRICE_LEVEL_INEXPENSIVE", "PRICE_LEVEL_MODERATE"}
+	if !reflect.DeepEqual(first["priceLevels"], levels) {
+		t.Errorf("expected price levels %v, got %v", levels, first["priceLevels"])
+	}
+
+	bodies = nil
+	places, err = client.TextSearch(TextQuery{Query: "best dumplings", Limit: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(places) != 2 || len(bodies) != 1 {
+		t.Errorf("expected only the first page without Pages, got %v from %d requests", places, len(bodies))
+	}
+}
+
+func TestNewClientDetails(t *testing.T) {
+	server, client := newPlacesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/places/ChIJ3bKbZvmipBIR" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(newDetailsFixture))
+	})
+	defer server.Close()
+
+	place := Place{ID: "ChIJ3bKbZvmipBIR"}
+	if err := place.GetDetails(client); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := Place{
+		ID:         "ChIJ3bKbZvmipBIR",
+		Name:       "Bar Marsella",
+		Website:    "http://www.example.com",
+		Rating:     4.3,
+		Location:   Location{Latitude: 41.3782, Longitude: 2.1718},
+		PriceLevel: 1,
+		OpeningHours: &OpeningHours{Periods: []OpeningPeriod{
+			{Open: DayTime{Day: 1, Time: "2200"}, Close: &DayTime{Day: 2, Time: "0230"}},
+		}},
+		UTCOffset: 120,
+	}
+	if !reflect.DeepEqual(expected, place) {
+		t.Errorf("expected %+v, got %+v", expected, place)
+	}
+}
+
+func TestNewClientPhotoAndErrors(t *testing.T) {
+	server, client := newPlacesServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/places/a/photos/b/media" {
+			if r.URL.Query().Get("skipHttpRedirect") != "true" || r.URL.Query().Get("maxWidthPx") != "400" {
+				t.Errorf("unexpected photo parameters %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"name": "places/a/photos/b/media", "photoUri": "https://lh3.googleusercontent.com/photo"}`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"code": 403, "message": "API key not valid", "status": "PERMISSION_DENIED"}}`))
+	})
+	defer server.Close()
+
+	url, err := client.Photo("places/a/photos/b", photoMaxWidth)
+	if err != nil || url != "https://lh3.googleusercontent.com/photo" {
+		t.Errorf("expected photo url, got %q, %v", url, err)
+	}
+	_, err = client.Details("a")
+	if err == nil || !strings.Contains(err.Error(), "API key not valid") {
+		t.Errorf("expected google's error message, got %v", err)
+	}
+}
+
+func TestNewPlacesProvider(t *testing.T) {
+	if _, ok := NewPlacesProvider(Config{PlacesProviders: []string{"google"}}, nil).(GooglePlacesClient); !ok {
+		t.Errorf("expected the legacy client by default")
+	}
+	c := Config{PlacesProviders: []string{"google"}, PlacesAPI: placesAPINew}
+	if _, ok := NewPlacesProvider(c, nil).(GooglePlacesNewClient); !ok {
+		t.Errorf("expected the Places API (New) client")
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetPlacesFromGoogleSuccess(t *testing.T) {
@@ -97,6 +99,78 @@ func TestLinkMapUrl(t *testing.T) {
 	if got != expected {
 		t.Errorf("expected to get static map url %s, got %s", expected, got)
 	}
+
+	for _, id := range []string{"fsq:4b5f7f1ef964a520d8bc29e3", "yelp:bar-marsella-barcelona", "osm:node/123"} {
+		place := Place{ID: id, Location: Location{Latitude: 41.3782, Longitude: 2.1718}}
+		expected := "https://www.google.com/maps/search/?api=1&query=41.3782,2.1718"
+		if got := place.LinkMapUrl(); got != expected {
+			t.Errorf("expected %s to link to %s, got %s", id, expected, got)
+		}
+	}
+}
+
+func TestMergePlaces(t *testing.T) {
+	curated := []Place{
+		Place{ID: "curated1", Name: "Bar Marsella", Curated: true},
+	}
+	google := []Place{
+		Place{ID: "google1", Name: "Cal Pep"},
+		Place{ID: "curated1", Name: "Bar Marsella"},
+		Place{ID: "google2", Name: "El Xampanyet"},
+		Place{ID: "google3", Name: "Bar del Pla"},
+	}
+
+	expected := []Place{
+		Place{ID: "curated1", Name: "Bar Marsella", Curated: true},
+		Place{ID: "google1", Name: "Cal Pep"},
+		Place{ID: "google2", Name: "El Xampanyet"},
+	}
+	got := MergePlaces(curated, google, 3)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if label := got[0].Label(); label != "Hungry Girl pick" {
+		t.Errorf("expected curated place to be labelled, got %q", label)
+	}
+	if label := got[1].Label(); label != "" {
+		t.Errorf("expected google place not to be labelled, got %q", label)
+	}
+}
+
+func TestPlaceSubtitle(t *testing.T) {
+	tests := []struct {
+		Place    Place
+		Expected string
+	}{
+		{Place: Place{}, Expected: ""},
+		{Place: Place{Curated: true}, Expected: "Hungry Girl pick"},
+		{Place: Place{Curated: true, Note: "Try the absinthe"}, Expected: "Hungry Girl pick: Try the absinthe"},
+	}
+
+	for _, test := range tests {
+		if got := test.Place.Subtitle(); got != test.Expected {
+			t.Errorf("expected subtitle %q, got %q", test.Expected, got)
+		}
+	}
+}
+
+func TestNormaliseTags(t *testing.T) {
+	place := Place{Cuisines: []string{" Tapas"}, Dietary: []string{"Vegan"}, PriceLevel: 2}
+	if err := place.normaliseTags(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if place.Cuisines[0] != "tapas" || place.Dietary[0] != "vegan" {
+		t.Errorf("expected lowercased tags, got %v %v", place.Cuisines, place.Dietary)
+	}
+
+	for _, invalid := range []Place{
+		{Dietary: []string{"paleo"}},
+		{PriceLevel: 5},
+	} {
+		if err := invalid.normaliseTags(); err == nil {
+			t.Errorf("expected error for %+v", invalid)
+		}
+	}
 }
 
 func newGooglePlacesSearchResponse(places []Place) GooglePlacesSearchResponse {
@@ -128,3 +202,80 @@ func mustGetResponse(t *testing.T, url string) *http.Response {
 	}
 	return resp
 }
+
+func TestTextSearchPagination(t *testing.T) {
+	defer func(delay time.Duration) { nextPageDelay = delay }(nextPageDelay)
+	nextPageDelay = 0
+
+	var requests []url.Values
+	googleServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Query())
+			if r.URL.Query().Get("pagetoken") == "" {
+				json.NewEncoder(w).Encode(GooglePlacesSearchResponse{
+					Results:       []Place{{ID: "a"}, {ID: "b"}},
+					NextPageToken: "page2",
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(GooglePlacesSearchResponse{
+				Results:       []Place{{ID: "c"}, {ID: "d"}},
+				NextPageToken: "page3",
+			})
+		}),
+	)
+	defer googleServer.Close()
+	client := GooglePlacesClient{BaseURL: googleServer.URL, APIKey: "key"}
+
+	places, err := client.TextSearch(TextQuery{
+		Query:    "best dumplings",
+		Location: &Location{Latitude: 51.5136, Longitude: -0.1365},
+		Type:     "restaurant",
+		Limit:    3,
+		Pages:    maxSearchPages,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(places) != 3 || places[2].ID != "c" {
+		t.Errorf("expected three places across two pages, got %v", places)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected two requests, got %d", len(requests))
+	}
+	first := requests[0]
+	if first.Get("location") != "51.5136,-0.1365" || first.Get("radius") != "1000" || first.Get("type") != "restaurant" {
+		t.Errorf("unexpected search parameters %v", first)
+	}
+	if second := requests[1]; second.Get("pagetoken") != "page2" || second.Get("key") != "key" {
+		t.Errorf("unexpected next page parameters %v", second)
+	}
+	requests = nil
+	if _, err := client.TextSearch(TextQuery{Query: "best dumplings", Limit: 3}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("expected only the first page without Pages, got %d requests", len(requests))
+	}
+}
+
+func TestPhoto(t *testing.T) {
+	googleServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("photo_reference"); got != "CmRaAAAA" {
+				t.Errorf("expected photo reference CmRaAAAA, got %s", got)
+			}
+			http.Redirect(w, r, "https://lh3.googleusercontent.com/photo", http.StatusFound)
+		}),
+	)
+	defer googleServer.Close()
+	client := GooglePlacesClient{BaseURL: googleServer.URL, APIKey: "key"}
+
+	url, err := client.Photo("CmRaAAAA", photoMaxWidth)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if url != "https://lh3.googleusercontent.com/photo" {
+		t.Errorf("expected redirect location, got %s", url)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultPlaceType = "restaurant"
+	// placeTypePayload prefixes the quick reply payload of a category,
+	// e.g. "TYPE:cafe".
+	placeTypePayload = "TYPE:"
+)
+
+// PlaceCategory is a Google place type users can ask for, with the words
+// that suggest they want it.
+type PlaceCategory struct {
+	Type  string
+	Title string
+	// Noun completes "somewhere ..." when confirming a search.
+	Noun  string
+	Words []string
+}
+
+// placeCategories are offered in this order in the category menu.
+var placeCategories = []PlaceCategory{
+	{Type: "restaurant", Title: "Restaurants", Noun: "to eat"},
+	{Type: "cafe", Title: "Coffee", Noun: "for coffee", Words: []string{"coffee", "cafe", "café", "brunch"}},
+	{Type: "bar", Title: "Drinks", Noun: "for a drink", Words: []string{"bar", "drink", "beer", "wine", "cocktail", "pub"}},
+	{Type: "bakery", Title: "Bakery", Noun: "for cake", Words: []string{"bakery", "pastry", "pastries", "cake", "bread"}},
+	{Type: "meal_takeaway", Title: "Takeaway", Noun: "to take away", Words: []string{"takeaway", "takeout", "take away", "take out"}},
+}
+
+// enabledCategories returns the categories of the given types, or all of
+// them if there are none.
+func enabledCategories(types []string) []PlaceCategory {
+	if len(types) == 0 {
+		return placeCategories
+	}
+	var enabled []PlaceCategory
+	for _, c := range placeCategories {
+		for _, t := range types {
+			if t == c.Type {
+				enabled = append(enabled, c)
+				break
+			}
+		}
+	}
+	return enabled
+}
+
+func findCategory(categories []PlaceCategory, placeType string) (PlaceCategory, bool) {
+	for _, c := range categories {
+		if c.Type == placeType {
+			return c, true
+		}
+	}
+	return PlaceCategory{}, false
+}
+
+// parsePlaceType guesses the kind of place wanted from a text message, e.g.
+// "coffee" means a cafe.
+func parsePlaceType(categories []PlaceCategory, text string) (string, bool) {
+	lower := strings.ToLower(text)
+	for _, c := range categories {
+		for _, word := range c.Words {
+			if containsWord(lower, word) {
+				return c.Type, true
+			}
+		}
+	}
+	return "", false
+}
+
+// containsWord matches word, or its plural with an s, as a whole word in text,
+// so "bar" matches "bars" but not "rhubarb" or "barista".
+func containsWord(text, word string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], word)
+		if j == -1 {
+			return false
+		}
+		j += i
+		end := j + len(word)
+		if end < len(text) && text[end] == 's' {
+			end++
+		}
+		if (j == 0 || !isLetter(text[j-1])) && (end == len(text) || !isLetter(text[end])) {
+			return true
+		}
+		i = j + 1
+	}
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || b >= 0x80
+}
+
+// placeTypesOrDefault stops curated places being stored without a type.
+func placeTypesOrDefault(types []string) []string {
+	if len(types) == 0 {
+		return []string{defaultPlaceType}
+	}
+	return types
+}
+
+// categoryQuickReplies offers every category other than the one just shown.
+func categoryQuickReplies(categories []PlaceCategory, current string) []FBQuickReply {
+	if current == "" {
+		current = defaultPlaceType
+	}
+	var replies []FBQuickReply
+	for _, c := range categories {
+		if c.Type == current {
+			continue
+		}
+		replies = append(replies, FBQuickReply{ContentType: "text", Title: c.Title, Payload: placeTypePayload + c.Type})
+	}
+	return replies
+}
+
+// handlePlaceType reruns the last search for another kind of place, or asks
+// for a location if there isn't one.
+func (app *App) handlePlaceType(FBUserID, placeType string) {
+	category, ok := findCategory(app.categories, placeType)
+	if !ok {
+		app.sendText(FBUserID, "Sorry, I can't search for that.")
+		return
+	}
+	search, ok := app.lastSearches.get(FBUserID)
+	if !ok {
+		req := SearchRequest{Type: category.Type}
+		app.pendingSearches.put(FBUserID, lastSearch{Request: req}, pendingSearchTTL)
+		app.sendLocationPrompt(FBUserID, fmt.Sprintf("Send me your location and I'll find somewhere %s.", req))
+		return
+	}
+	req := search.Request
+	req.Type = category.Type
+	defer app.showTyping(FBUserID)()
+	app.sendText(FBUserID, fmt.Sprintf("Looking for somewhere %s...", req))
+	app.recommend(FBUserID, search.Location, req)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePlaceType(t *testing.T) {
+	tests := []struct {
+		Text  string
+		Type  string
+		Found bool
+	}{
+		{Text: "Coffee near me?", Type: "cafe", Found: true},
+		{Text: "any good bars", Type: "bar", Found: true},
+		{Text: "somewhere for cake", Type: "bakery", Found: true},
+		{Text: "I want a takeaway", Type: "meal_takeaway", Found: true},
+		{Text: "a quiet pub", Type: "bar", Found: true},
+		{Text: "rhubarb crumble"},
+		{Text: "Barcelona"},
+		{Text: "barbecue"},
+		{Text: "a good barista"},
+		{Text: "public toilets"},
+	}
+
+	for _, test := range tests {
+		got, found := parsePlaceType(placeCategories, test.Text)
+		if got != test.Type || found != test.Found {
+			t.Errorf("expected %q, %t for %q, got %q, %t", test.Type, test.Found, test.Text, got, found)
+		}
+	}
+}
+
+func TestParsePlaceTypeRespectsConfig(t *testing.T) {
+	categories := enabledCategories([]string{"restaurant", "bar"})
+
+	if got, found := parsePlaceType(categories, "coffee"); found {
+		t.Errorf("expected cafe to be disabled, got %q", got)
+	}
+	replies := categoryQuickReplies(categories, "")
+	if len(replies) != 1 || replies[0].Payload != "TYPE:bar" {
+		t.Errorf("expected only drinks to be offered, got %v", replies)
+	}
+}
+
+func TestSearchNearbyType(t *testing.T) {
+	var gotType string
+	googleServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			gotType = r.URL.Query().Get("type")
+			json.NewEncoder(w).Encode(GooglePlacesSearchResponse{
+				Results: []Place{{ID: "a", Name: "Satan's Coffee Corner", Types: []string{"cafe", "food"}}},
+			})
+		}),
+	)
+	defer googleServer.Close()
+	client := GooglePlacesClient{BaseURL: googleServer.URL}
+
+	if _, err := client.Nearby(Location{}, NearbySearch{Limit: 3}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotType != "restaurant" {
+		t.Errorf("expected restaurants by default, got %q", gotType)
+	}
+	places, err := client.Nearby(Location{}, NearbySearch{Limit: 3, Type: "cafe"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotType != "cafe" {
+		t.Errorf("expected cafes, got %q", gotType)
+	}
+	if len(places) != 1 || len(places[0].Types) != 2 {
+		t.Errorf("expected types to be decoded, got %v", places)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	// preferencesPayload opens the preferences menu from the persistent menu.
+	preferencesPayload = "PREFERENCES"
+	// preferencePayload prefixes the preferences menu's quick replies, e.g.
+	// "PREF:budget".
+	preferencePayload = "PREF:"
+	// budgetPayload prefixes a budget's quick reply payload, e.g. "BUDGET:2",
+	// with 0 for any.
+	budgetPayload = "BUDGET:"
+	// cuisinePayload prefixes a cuisine's quick reply payload, e.g.
+	// "CUISINE:thai", or "CUISINE:done" once the user has picked them.
+	cuisinePayload = "CUISINE:"
+	cuisinesDone   = "done"
+)
+
+// favouriteCuisines are offered when choosing favourites, as they're tagged
+// on curated places.
+var favouriteCuisines = []string{"italian", "indian", "chinese", "japanese", "thai", "vietnamese", "mexican", "french", "korean", "turkish"}
+
+var cuisineTitles = map[string]string{
+	"italian":    "Italian",
+	"indian":     "Indian",
+	"chinese":    "Chinese",
+	"japanese":   "Japanese",
+	"thai":       "Thai",
+	"vietnamese": "Vietnamese",
+	"mexican":    "Mexican",
+	"french":     "French",
+	"korean":     "Korean",
+	"turkish":    "Turkish",
+}
+
+// handlePreferences shows the user's preferences and offers to change them.
+func (app *App) handlePreferences(FBUserID string) {
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+		return
+	}
+	replies := []FBQuickReply{
+		{ContentType: "text", Title: "Dietary", Payload: preferencePayload + "dietary"},
+		{ContentType: "text", Title: "Budget", Payload: preferencePayload + "budget"},
+		{ContentType: "text", Title: "Cuisines", Payload: preferencePayload + "cuisines"},
+	}
+	if user.hasPreferences() {
+		replies = append(replies, FBQuickReply{ContentType: "text", Title: "Clear all", Payload: preferencePayload + "clear"})
+	}
+	app.sendQuickReplies(FBUserID, user.describePreferences()+" What would you like to change?", replies...)
+}
+
+func (app *App) handlePreferenceReply(FBUserID, preference string) {
+	switch preference {
+	case "dietary":
+		app.askDietary(FBUserID, "Which of these do you need?")
+	case "budget":
+		replies := []FBQuickReply{{ContentType: "text", Title: "Any", Payload: budgetPayload + "0"}}
+		for level := 1; level <= 4; level++ {
+			replies = append(replies, FBQuickReply{ContentType: "text", Title: strings.Repeat("£", level), Payload: budgetPayload + strconv.Itoa(level)})
+		}
+		app.sendQuickReplies(FBUserID, "What's the most you'd like to spend?", replies...)
+	case "cuisines":
+		user, err := app.Users.Get(FBUserID)
+		if err != nil {
+			log.Println("error getting user: ", err)
+			return
+		}
+		app.sendQuickReplies(FBUserID, "Which cuisines do you love? I'll suggest those places first.", cuisineQuickReplies(user.Cuisines)...)
+	case "clear":
+		user, err := app.Users.Get(FBUserID)
+		if err != nil {
+			log.Println("error getting user: ", err)
+			return
+		}
+		user.Dietary, user.Budget, user.Cuisines = nil, 0, nil
+		if err := app.Users.Save(user); err != nil {
+			log.Println("error saving user: ", err)
+			return
+		}
+		app.sendText(FBUserID, "Done, I've forgotten your preferences.")
+	default:
+		log.Printf("unknown preference %q", preference)
+	}
+}
+
+func (app *App) handleBudgetReply(FBUserID, value string) {
+	budget, err := strconv.Atoi(value)
+	if err != nil || budget < 0 || budget > 4 {
+		log.Printf("invalid budget %q", value)
+		return
+	}
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+		return
+	}
+	user.Budget = budget
+	if err := app.Users.Save(user); err != nil {
+		log.Println("error saving user: ", err)
+		return
+	}
+	if budget == 0 {
+		app.sendText(FBUserID, "Got it, I won't worry about price.")
+		return
+	}
+	app.sendText(FBUserID, fmt.Sprintf("Got it, I'll stick to places up to %s.", strings.Repeat("£", budget)))
+}
+
+// handleCuisineReply adds or removes a favourite cuisine and asks for more,
+// or shows the user's preferences once they're done.
+func (app *App) handleCuisineReply(FBUserID, cuisine string) {
+	if cuisine == cuisinesDone {
+		app.handlePreferences(FBUserID)
+		return
+	}
+	if !containsString(favouriteCuisines, cuisine) {
+		log.Printf("unknown cuisine %q", cuisine)
+		return
+	}
+	user, err := app.Users.Get(FBUserID)
+	if err != nil {
+		log.Println("error getting user: ", err)
+		return
+	}
+	if containsString(user.Cuisines, cuisine) {
+		user.Cuisines = removeString(user.Cuisines, cuisine)
+	} else {
+		user.Cuisines = append(user.Cuisines, cuisine)
+	}
+	if err := app.Users.Save(user); err != nil {
+		log.Println("error saving user: ", err)
+		return
+	}
+	app.sendQuickReplies(FBUserID, "Any others?", cuisineQuickReplies(user.Cuisines)...)
+}
+
+// cuisineQuickReplies offers every cuisine, ticking those already chosen, so
+// tapping one toggles it.
+func cuisineQuickReplies(chosen []string) []FBQuickReply {
+	var replies []FBQuickReply
+	for _, cuisine := range favouriteCuisines {
+		title := cuisineTitles[cuisine]
+		if containsString(chosen, cuisine) {
+			title = "✓ " + title
+		}
+		replies = append(replies, FBQuickReply{ContentType: "text", Title: title, Payload: cuisinePayload + cuisine})
+	}
+	return append(replies, FBQuickReply{ContentType: "text", Title: "Done", Payload: cuisinePayload + cuisinesDone})
+}
+
+func (u User) hasPreferences() bool {
+	return len(u.Dietary) > 0 || u.Budget > 0 || len(u.Cuisines) > 0
+}
+
+// describePreferences summarises the user's preferences. Only curated places
+// are checked against dietary requirements, so it says so.
+func (u User) describePreferences() string {
+	if !u.hasPreferences() {
+		return "You haven't told me any preferences yet."
+	}
+	var parts []string
+	if u.Budget > 0 {
+		parts = append(parts, "up to "+strings.Repeat("£", u.Budget))
+	}
+	if len(u.Cuisines) > 0 {
+		var titles []string
+		for _, c := range u.Cuisines {
+			titles = append(titles, cuisineTitles[c])
+		}
+		parts = append(parts, strings.Join(titles, ", ")+" first")
+	}
+	var sentences []string
+	if len(parts) > 0 {
+		sentences = append(sentences, "I'm suggesting "+strings.Join(parts, ", ")+".")
+	}
+	if len(u.Dietary) > 0 {
+		var titles []string
+		for _, flag := range u.Dietary {
+			titles = append(titles, strings.ToLower(dietaryTitles[flag]))
+		}
+		sentences = append(sentences, "The places I recommend myself are "+strings.Join(titles, " and ")+", but I can't check the ones from Google.")
+	}
+	return strings.Join(sentences, " ")
+}
+
+func removeString(list []string, s string) []string {
+	var kept []string
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDescribePreferences(t *testing.T) {
+	tests := []struct {
+		User     User
+		Expected string
+	}{
+		{User: User{}, Expected: "You haven't told me any preferences yet."},
+		{User: User{Budget: 2}, Expected: "I'm suggesting up to ££."},
+		{
+			User:     User{Dietary: []string{"vegan", "gluten_free"}, Budget: 1, Cuisines: []string{"thai", "korean"}},
+			Expected: "I'm suggesting up to £, Thai, Korean first. The places I recommend myself are vegan and gluten free, but I can't check the ones from Google.",
+		},
+		{
+			User:     User{Dietary: []string{"halal"}},
+			Expected: "The places I recommend myself are halal, but I can't check the ones from Google.",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.User.describePreferences(); got != test.Expected {
+			t.Errorf("expected %q, got %q", test.Expected, got)
+		}
+	}
+}
+
+func TestHandlePreferences(t *testing.T) {
+	sender := &fakeSender{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = fakeUserStore{"1234": {PSID: "1234", Budget: 3}}
+
+	app.handlePreferences("1234")
+
+	var payloads []string
+	for _, reply := range sender.Sent[0].Message.QuickReplies {
+		payloads = append(payloads, reply.Payload)
+	}
+	expected := []string{"PREF:dietary", "PREF:budget", "PREF:cuisines", "PREF:clear"}
+	if !reflect.DeepEqual(payloads, expected) {
+		t.Errorf("expected %v offered, got %v", expected, payloads)
+	}
+}
+
+func TestHandleBudgetReply(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Budget   int
+		Expected string
+	}{
+		{Value: "2", Budget: 2, Expected: "Got it, I'll stick to places up to ££."},
+		{Value: "0", Budget: 0, Expected: "Got it, I won't worry about price."},
+		{Value: "9", Budget: 3},
+	}
+
+	for _, test := range tests {
+		sender := &fakeSender{}
+		users := fakeUserStore{"1234": {PSID: "1234", Budget: 3}}
+		app := newApp(Config{}, nil, stubProvider{}, sender)
+		app.Users = users
+
+		app.handleBudgetReply("1234", test.Value)
+
+		if users["1234"].Budget != test.Budget {
+			t.Errorf("expected budget %d for %q, got %d", test.Budget, test.Value, users["1234"].Budget)
+		}
+		var expected []string
+		if test.Expected != "" {
+			expected = []string{test.Expected}
+		}
+		if !reflect.DeepEqual(sender.Log, expected) {
+			t.Errorf("expected %q for %q, got %q", expected, test.Value, sender.Log)
+		}
+	}
+}
+
+func TestHandleCuisineReplyToggles(t *testing.T) {
+	sender := &fakeSender{}
+	users := fakeUserStore{}
+	app := newApp(Config{}, nil, stubProvider{}, sender)
+	app.Users = users
+
+	app.handleCuisineReply("1234", "thai")
+	app.handleCuisineReply("1234", "korean")
+	if expected := []string{"thai", "korean"}; !reflect.DeepEqual(users["1234"].Cuisines, expected) {
+		t.Errorf("expected %v saved, got %v", expected, users["1234"].Cuisines)
+	}
+	replies := sender.Sent[len(sender.Sent)-1].Message.QuickReplies
+	if replies[4].Title != "✓ Thai" || replies[0].Title != "Italian" || replies[len(replies)-1].Payload != "CUISINE:done" {
+		t.Errorf("expected chosen cuisines ticked, got %v", replies)
+	}
+
+	app.handleCuisineReply("1234", "thai")
+	if expected := []string{"korean"}; !reflect.DeepEqual(users["1234"].Cuisines, expected) {
+		t.Errorf("expected thai removed, got %v", users["1234"].Cuisines)
+	}
+}
+
+func TestHandlePreferenceReplyClears(t *testing.T) {
+	users := fakeUserStore{"1234": {PSID: "1234", Locale: "en_GB", Dietary: []string{"halal"}, Budget: 2, Cuisines: []string{"thai"}}}
+	app := newApp(Config{}, nil, stubProvider{}, &fakeSender{})
+	app.Users = users
+
+	app.handlePreferenceReply("1234", "clear")
+
+	if users["1234"].hasPreferences() {
+		t.Errorf("expected preferences cleared, got %+v", users["1234"])
+	}
+	if users["1234"].Locale != "en_GB" {
+		t.Errorf("expected locale kept, got %q", users["1234"].Locale)
+	}
+}
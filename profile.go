@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+const (
+	getStartedPayload     = "GET_STARTED"
+	recommendationFormURL = "https://nicolaa.typeform.com/to/noVPUi"
+	defaultLocale         = "default"
+)
+
+// profileFields are the Messenger profile properties we manage. Any others,
+// such as whitelisted domains, are left alone.
+var profileFields = []string{"get_started", "greeting", "persistent_menu", "ice_breakers"}
+
+// MessengerProfile is a page's Messenger profile: what people see before and
+// around their conversations with the bot.
+type MessengerProfile struct {
+	GetStarted     *ProfileGetStarted `json:"get_started,omitempty"`
+	Greeting       []ProfileGreeting  `json:"greeting,omitempty"`
+	PersistentMenu []PersistentMenu   `json:"persistent_menu,omitempty"`
+	IceBreakers    []IceBreakers      `json:"ice_breakers,omitempty"`
+}
+
+type ProfileGetStarted struct {
+	Payload string `json:"payload"`
+}
+
+type ProfileGreeting struct {
+	Locale string `json:"locale"`
+	Text   string `json:"text"`
+}
+
+type PersistentMenu struct {
+	Locale                string     `json:"locale"`
+	ComposerInputDisabled bool       `json:"composer_input_disabled"`
+	CallToActions         []MenuItem `json:"call_to_actions"`
+}
+
+// MenuItem is a postback or web_url button, or a nested menu of them.
+type MenuItem struct {
+	Type          string     `json:"type"`
+	Title         string     `json:"title"`
+	Payload       string     `json:"payload,omitempty"`
+	Url           string     `json:"url,omitempty"`
+	CallToActions []MenuItem `json:"call_to_actions,omitempty"`
+}
+
+type IceBreakers struct {
+	Locale        string       `json:"locale"`
+	CallToActions []IceBreaker `json:"call_to_actions"`
+}
+
+type IceBreaker struct {
+	Question string `json:"question"`
+	Payload  string `json:"payload"`
+}
+
+// profileText is the profile's wording in one locale.
+type profileText struct {
+	Locale      string
+	Greeting    string
+	Search      string
+	Recommend   string
+	Preferences string
+	Categories  map[string]string
+	Questions   []IceBreaker
+}
+
+// profileLocales are the locales the profile is written in, the default
+// first. Category titles fall back to PlaceCategory.Title.
+var profileLocales = []profileText{
+	{
+		Locale:      defaultLocale,
+		Greeting:    "Hi {{user_first_name}}! Send me your location and I'll find somewhere good to eat nearby.",
+		Search:      "Find somewhere",
+		Recommend:   "Make a recommendation",
+		Preferences: "Preferences",
+		Questions: []IceBreaker{
+			{Question: "Where can I get a coffee?", Payload: placeTypePayload + "cafe"},
+			{Question: "Somewhere cheap to eat?", Payload: cheaperPayload},
+			{Question: "How does this work?", Payload: getStartedPayload},
+		},
+	},
+	{
+		Locale:      "es_ES",
+		Greeting:    "¡Hola {{user_first_name}}! Envíame tu ubicación y te diré dónde comer bien cerca.",
+		Search:      "Buscar",
+		Recommend:   "Recomendar un sitio",
+		Preferences: "Preferencias",
+		Categories: map[string]string{
+			"restaurant":    "Restaurantes",
+			"cafe":          "Café",
+			"bar":           "Copas",
+			"bakery":        "Panadería",
+			"meal_takeaway": "Para llevar",
+		},
+		Questions: []IceBreaker{
+			{Question: "¿Dónde tomo un café?", Payload: placeTypePayload + "cafe"},
+			{Question: "¿Algo barato para comer?", Payload: cheaperPayload},
+			{Question: "¿Cómo funciona?", Payload: getStartedPayload},
+		},
+	},
+}
+
+// messengerProfile declares the profile the bot should have, with a search
+// menu of the given categories.
+func messengerProfile(categories []PlaceCategory) MessengerProfile {
+	profile := MessengerProfile{
+		GetStarted: &ProfileGetStarted{Payload: getStartedPayload},
+	}
+	for _, text := range profileLocales {
+		profile.Greeting = append(profile.Greeting, ProfileGreeting{Locale: text.Locale, Text: text.Greeting})
+
+		var search []MenuItem
+		for _, c := range categories {
+			title := text.Categories[c.Type]
+			if title == "" {
+				title = c.Title
+			}
+			search = append(search, MenuItem{Type: "postback", Title: title, Payload: placeTypePayload + c.Type})
+		}
+		profile.PersistentMenu = append(profile.PersistentMenu, PersistentMenu{
+			Locale: text.Locale,
+			CallToActions: []MenuItem{
+				{Type: "nested", Title: text.Search, CallToActions: search},
+				{Type: "web_url", Title: text.Recommend, Url: recommendationFormURL},
+				{Type: "postback", Title: text.Preferences, Payload: preferencesPayload},
+			},
+		})
+		profile.IceBreakers = append(profile.IceBreakers, IceBreakers{Locale: text.Locale, CallToActions: text.Questions})
+	}
+	return profile
+}
+
+// ProfileManager keeps a page's Messenger profile in line with a declared
+// one, using the Messenger Profile API.
+type ProfileManager struct {
+	Client *MessengerClient
+}
+
+// ProfileChanges are the profile fields that need setting or deleting.
+type ProfileChanges struct {
+	Set    map[string]json.RawMessage
+	Delete []string
+}
+
+func (c ProfileChanges) Empty() bool {
+	return len(c.Set) == 0 && len(c.Delete) == 0
+}
+
+func (c ProfileChanges) String() string {
+	var parts []string
+	for _, field := range profileFields {
+		if _, ok := c.Set[field]; ok {
+			parts = append(parts, "set "+field)
+		}
+	}
+	for _, field := range c.Delete {
+		parts = append(parts, "delete "+field)
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Current fetches the fields of the page's profile that we manage.
+func (pm ProfileManager) Current() (MessengerProfile, error) {
+	var resp struct {
+		Data []MessengerProfile `json:"data"`
+	}
+	path := "/me/messenger_profile?" + url.Values{"fields": {strings.Join(profileFields, ",")}}.Encode()
+	if err := pm.Client.do("GET", path, nil, &resp); err != nil {
+		return MessengerProfile{}, err
+	}
+	if len(resp.Data) == 0 {
+		// A page with no profile set returns no data.
+		return MessengerProfile{}, nil
+	}
+	return resp.Data[0], nil
+}
+
+// Sync updates the fields of the page's profile that differ from want, and
+// returns what it changed.
+func (pm ProfileManager) Sync(want MessengerProfile, dryRun bool) (ProfileChanges, error) {
+	current, err := pm.Current()
+	if err != nil {
+		return ProfileChanges{}, err
+	}
+	changes, err := diffProfiles(current, want)
+	if err != nil || dryRun {
+		return changes, err
+	}
+	if len(changes.Set) > 0 {
+		if err := pm.Client.post("/me/messenger_profile", changes.Set); err != nil {
+			return changes, err
+		}
+	}
+	if len(changes.Delete) > 0 {
+		body, err := json.Marshal(map[string][]string{"fields": changes.Delete})
+		if err != nil {
+			return changes, err
+		}
+		if err := pm.Client.do("DELETE", "/me/messenger_profile", body, nil); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// diffProfiles compares the managed fields of two profiles.
+func diffProfiles(current, want MessengerProfile) (ProfileChanges, error) {
+	currentFields, err := profileFieldsJSON(current)
+	if err != nil {
+		return ProfileChanges{}, err
+	}
+	wantFields, err := profileFieldsJSON(want)
+	if err != nil {
+		return ProfileChanges{}, err
+	}
+	changes := ProfileChanges{Set: make(map[string]json.RawMessage)}
+	for _, field := range profileFields {
+		w, wanted := wantFields[field]
+		c, set := currentFields[field]
+		switch {
+		case wanted && (!set || !bytes.Equal(w, c)):
+			changes.Set[field] = w
+		case !wanted && set:
+			changes.Delete = append(changes.Delete, field)
+		}
+	}
+	return changes, nil
+}
+
+func profileFieldsJSON(p MessengerProfile) (map[string]json.RawMessage, error) {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	return fields, json.Unmarshal(buf, &fields)
+}
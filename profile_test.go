@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMessengerProfile(t *testing.T) {
+	profile := messengerProfile(enabledCategories([]string{"restaurant", "cafe"}))
+
+	if profile.GetStarted == nil || profile.GetStarted.Payload != getStartedPayload {
+		t.Errorf("expected a get started button, got %v", profile.GetStarted)
+	}
+	if len(profile.PersistentMenu) != len(profileLocales) || profile.PersistentMenu[0].Locale != defaultLocale {
+		t.Fatalf("expected a menu per locale, default first, got %v", profile.PersistentMenu)
+	}
+	search := profile.PersistentMenu[1].CallToActions[0]
+	expected := []MenuItem{
+		{Type: "postback", Title: "Restaurantes", Payload: "TYPE:restaurant"},
+		{Type: "postback", Title: "Café", Payload: "TYPE:cafe"},
+	}
+	if search.Type != "nested" || !reflect.DeepEqual(search.CallToActions, expected) {
+		t.Errorf("expected a nested search menu of %v, got %v", expected, search)
+	}
+	preferences := profile.PersistentMenu[0].CallToActions[2]
+	if preferences.Type != "postback" || preferences.Payload != preferencesPayload {
+		t.Errorf("expected a preferences button, got %v", preferences)
+	}
+}
+
+func TestProfileManagerSync(t *testing.T) {
+	want := messengerProfile(placeCategories)
+	unchanged, _ := json.Marshal(map[string]interface{}{"data": []MessengerProfile{want}})
+	outdated := want
+	outdated.Greeting = []ProfileGreeting{{Locale: defaultLocale, Text: "Hello"}}
+	outdated.IceBreakers = nil
+	changed, _ := json.Marshal(map[string]interface{}{"data": []MessengerProfile{outdated}})
+
+	tests := []struct {
+		Current  []byte
+		Expected []string
+	}{
+		{Current: unchanged},
+		{Current: changed, Expected: []string{"greeting", "ice_breakers"}},
+		{Current: []byte(`{"data": []}`), Expected: profileFields},
+	}
+
+	for _, test := range tests {
+		var posted map[string]json.RawMessage
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/me/messenger_profile" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				switch r.Method {
+				case "GET":
+					w.Write(test.Current)
+				case "POST":
+					body, _ := ioutil.ReadAll(r.Body)
+					json.Unmarshal(body, &posted)
+					w.Write([]byte(`{"result": "success"}`))
+				default:
+					t.Errorf("unexpected %s request", r.Method)
+				}
+			}),
+		)
+		manager := ProfileManager{Client: testMessengerClient(server.URL)}
+		changes, err := manager.Sync(want, false)
+		server.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var got []string
+		for field := range posted {
+			got = append(got, field)
+		}
+		sort.Strings(got)
+		expected := append([]string(nil), test.Expected...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected %v to be updated, got %v", expected, got)
+		}
+		if changes.Empty() != (len(test.Expected) == 0) {
+			t.Errorf("expected changes %v, got %s", test.Expected, changes)
+		}
+	}
+}
+
+func TestDiffProfilesDeletesUnwantedFields(t *testing.T) {
+	current := MessengerProfile{IceBreakers: []IceBreakers{{Locale: defaultLocale}}}
+	changes, err := diffProfiles(current, MessengerProfile{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(changes.Set) != 0 || !reflect.DeepEqual(changes.Delete, []string{"ice_breakers"}) {
+		t.Errorf("expected ice breakers to be deleted, got %s", changes)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	placesAPILegacy = "legacy"
+	placesAPINew    = "new"
+	// photoMaxWidth suits Messenger's generic template images.
+	photoMaxWidth = 400
+
+	providerGoogle     = "google"
+	providerFoursquare = "foursquare"
+	providerYelp       = "yelp"
+	providerOSM        = "osm"
+
+	errNoPlacesProvider = "no places provider for place"
+)
+
+// providerIDPrefixes mark the IDs and photo references of places from
+// providers other than Google, whose IDs are left as they are so they match
+// curated places.
+var providerIDPrefixes = map[string]string{
+	providerFoursquare: "fsq:",
+	providerYelp:       "yelp:",
+	providerOSM:        "osm:",
+}
+
+// PlacesProvider finds places and their details. GooglePlacesClient talks to
+// the legacy Places API and GooglePlacesNewClient to the Places API (New).
+type PlacesProvider interface {
+	Nearby(location Location, search NearbySearch) ([]Place, error)
+	TextSearch(q TextQuery) ([]Place, error)
+	Details(placeID string) (Place, error)
+	// Photo returns a public URL for a photo from Place.Photos.
+	Photo(reference string, maxWidth int) (string, error)
+}
+
+type NamedProvider struct {
+	Name string
+	PlacesProvider
+}
+
+// FallbackProvider searches each provider in turn until one finds something,
+// and asks whichever provider a place came from for its details and photos.
+type FallbackProvider struct {
+	Providers []NamedProvider
+}
+
+// NewPlacesProvider returns the providers listed in c.PlacesProviders, Google
+// by default. c.PlacesAPI chooses which Google Places API to use. Other
+// providers are always wrapped in a FallbackProvider, so they're never asked
+// about curated places' Google IDs.
+func NewPlacesProvider(c Config, DB *sql.DB) PlacesProvider {
+	var providers []NamedProvider
+	for _, name := range c.PlacesProviders {
+		provider, err := newNamedProvider(name, c, DB)
+		if err != nil {
+			log.Printf("skipping places provider %s: %s", name, err)
+			continue
+		}
+		providers = append(providers, NamedProvider{Name: name, PlacesProvider: provider})
+	}
+	switch len(providers) {
+	case 0:
+		return NewGooglePlacesClient(c)
+	case 1:
+		if providers[0].Name == providerGoogle {
+			return providers[0].PlacesProvider
+		}
+		return FallbackProvider{Providers: providers}
+	default:
+		return FallbackProvider{Providers: providers}
+	}
+}
+
+func newNamedProvider(name string, c Config, DB *sql.DB) (PlacesProvider, error) {
+	switch name {
+	case providerGoogle:
+		if c.PlacesAPI == placesAPINew {
+			return NewGooglePlacesNewClient(c), nil
+		}
+		return NewGooglePlacesClient(c), nil
+	case providerFoursquare:
+		return NewFoursquareClient(c), nil
+	case providerYelp:
+		return NewYelpClient(c), nil
+	case providerOSM:
+		if c.OSMExtract == "" {
+			return OSMIndexProvider{DB: DB}, nil
+		}
+		return NewOSMProvider(c.OSMExtract, c.OSMTimezone)
+	default:
+		return nil, fmt.Errorf("unknown places provider %q", name)
+	}
+}
+
+func (f FallbackProvider) Nearby(location Location, search NearbySearch) ([]Place, error) {
+	return f.search(func(p PlacesProvider) ([]Place, error) {
+		return p.Nearby(location, search)
+	})
+}
+
+func (f FallbackProvider) TextSearch(q TextQuery) ([]Place, error) {
+	return f.search(func(p PlacesProvider) ([]Place, error) {
+		return p.TextSearch(q)
+	})
+}
+
+// search returns the first provider's results that aren't empty, or the last
+// error if every provider failed.
+func (f FallbackProvider) search(find func(PlacesProvider) ([]Place, error)) ([]Place, error) {
+	var lastErr error
+	for _, provider := range f.Providers {
+		places, err := find(provider)
+		if err != nil {
+			log.Printf("error searching %s: %s", provider.Name, err)
+			lastErr = err
+			continue
+		}
+		if len(places) > 0 {
+			return places, nil
+		}
+	}
+	return nil, lastErr
+}
+
+func (f FallbackProvider) Details(placeID string) (Place, error) {
+	provider, err := f.providerFor(placeID)
+	if err != nil {
+		return Place{}, err
+	}
+	return provider.Details(placeID)
+}
+
+func (f FallbackProvider) Photo(reference string, maxWidth int) (string, error) {
+	provider, err := f.providerFor(reference)
+	if err != nil {
+		return "", err
+	}
+	return provider.Photo(reference, maxWidth)
+}
+
+func (f FallbackProvider) providerFor(id string) (PlacesProvider, error) {
+	name := providerName(id)
+	for _, provider := range f.Providers {
+		if provider.Name == name {
+			return provider.PlacesProvider, nil
+		}
+	}
+	return nil, errors.New(errNoPlacesProvider)
+}
+
+// canLookUp reports whether provider can fetch the details of the place with
+// id, which only the provider it came from can do.
+func canLookUp(provider PlacesProvider, id string) bool {
+	if f, ok := provider.(FallbackProvider); ok {
+		_, err := f.providerFor(id)
+		return err == nil
+	}
+	return true
+}
+
+// providerName tells which provider an ID or photo reference came from.
+func providerName(id string) string {
+	for name, prefix := range providerIDPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return name
+		}
+	}
+	return providerGoogle
+}
+
+// getJSON decodes a successful response from one of the other providers'
+// JSON APIs.
+func getJSON(requestUrl string, header http.Header, v interface{}) error {
+	req, err := http.NewRequest("GET", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	client := &http.Client{Timeout: providerTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error retrieving %s: %s", requestUrl, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// placeImageUrl prefers a photo of the place for its card.
+func placeImageUrl(provider PlacesProvider, p Place) string {
+	if len(p.Photos) > 0 {
+		url, err := provider.Photo(p.Photos[0].Reference, photoMaxWidth)
+		if err == nil {
+			return url
+		}
+	}
+	return p.StaticMapUrl()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type stubProvider struct {
+	Places []Place
+	Err    error
+	Name   string
+}
+
+func (s stubProvider) Nearby(Location, NearbySearch) ([]Place, error) { return s.Places, s.Err }
+func (s stubProvider) TextSearch(TextQuery) ([]Place, error)          { return s.Places, s.Err }
+func (s stubProvider) Details(id string) (Place, error)               { return Place{ID: id, Name: s.Name}, s.Err }
+func (s stubProvider) Photo(ref string, _ int) (string, error)        { return s.Name + "/" + ref, s.Err }
+
+func TestFallbackProviderSearch(t *testing.T) {
+	tests := []struct {
+		Providers []NamedProvider
+		Expected  []Place
+		Err       bool
+	}{
+		{
+			Providers: []NamedProvider{
+				{Name: providerGoogle, PlacesProvider: stubProvider{Err: errors.New("over quota")}},
+				{Name: providerFoursquare, PlacesProvider: stubProvider{}},
+				{Name: providerOSM, PlacesProvider: stubProvider{Places: []Place{{ID: "osm:node/1"}}}},
+			},
+			Expected: []Place{{ID: "osm:node/1"}},
+		},
+		{
+			Providers: []NamedProvider{
+				{Name: providerGoogle, PlacesProvider: stubProvider{Places: []Place{{ID: "a"}}}},
+				{Name: providerOSM, PlacesProvider: stubProvider{Places: []Place{{ID: "osm:node/1"}}}},
+			},
+			Expected: []Place{{ID: "a"}},
+		},
+		{
+			Providers: []NamedProvider{
+				{Name: providerGoogle, PlacesProvider: stubProvider{Err: errors.New("over quota")}},
+			},
+			Err: true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := FallbackProvider{Providers: test.Providers}.Nearby(Location{}, NearbySearch{})
+		if (err != nil) != test.Err || !reflect.DeepEqual(got, test.Expected) {
+			t.Errorf("expected %v, %t, got %v, %v", test.Expected, test.Err, got, err)
+		}
+	}
+}
+
+func TestFallbackProviderRoutesByID(t *testing.T) {
+	provider := FallbackProvider{Providers: []NamedProvider{
+		{Name: providerGoogle, PlacesProvider: stubProvider{Name: "google"}},
+		{Name: providerYelp, PlacesProvider: stubProvider{Name: "yelp"}},
+	}}
+
+	for id, name := range map[string]string{"ChIJ3bKbZvmipBIR": "google", "yelp:bar-marsella": "yelp"} {
+		place, err := provider.Details(id)
+		if err != nil || place.Name != name {
+			t.Errorf("expected %s details for %s, got %v, %v", name, id, place, err)
+		}
+	}
+	if url, _ := provider.Photo("yelp:https://s3-media.fl.yelpcdn.com/o.jpg", photoMaxWidth); url != "yelp/yelp:https://s3-media.fl.yelpcdn.com/o.jpg" {
+		t.Errorf("expected yelp photo, got %s", url)
+	}
+	if _, err := provider.Details("fsq:4b0588"); err == nil || err.Error() != errNoPlacesProvider {
+		t.Errorf("expected error for a provider that isn't configured, got %v", err)
+	}
+}
+
+func TestCanLookUp(t *testing.T) {
+	osmOnly := FallbackProvider{Providers: []NamedProvider{{Name: providerOSM, PlacesProvider: stubProvider{}}}}
+	tests := []struct {
+		Provider PlacesProvider
+		ID       string
+		Expected bool
+	}{
+		{Provider: stubProvider{}, ID: "ChIJ3bKbZvmipBIR", Expected: true},
+		{Provider: osmOnly, ID: "osm:node/123", Expected: true},
+		{Provider: osmOnly, ID: "ChIJ3bKbZvmipBIR"},
+	}
+
+	for _, test := range tests {
+		if got := canLookUp(test.Provider, test.ID); got != test.Expected {
+			t.Errorf("expected %t for %s with %v, got %t", test.Expected, test.ID, test.Provider, got)
+		}
+	}
+}
+
+func TestNewPlacesProviderFallback(t *testing.T) {
+	if _, ok := NewPlacesProvider(Config{PlacesProviders: []string{"osm"}}, nil).(FallbackProvider); !ok {
+		t.Errorf("expected a lone osm provider to be wrapped in a fallback provider")
+	}
+
+	c := Config{PlacesProviders: splitList("google, foursquare, osm, bing")}
+	provider, ok := NewPlacesProvider(c, nil).(FallbackProvider)
+	if !ok {
+		t.Fatalf("expected a fallback provider")
+	}
+	var names []string
+	for _, p := range provider.Providers {
+		names = append(names, p.Name)
+	}
+	if expected := []string{"google", "foursquare", "osm"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected providers %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// searchRadiusMiles is the radius curated places are searched within, as
+	// used by the earthdistance <@> operator.
+	searchRadiusMiles = 0.5 / 1.6
+	// freshnessDays is how quickly a recommendation goes stale; its freshness
+	// decays by a factor of e every freshnessDays.
+	freshnessDays = 90
+)
+
+// withinRadiusSQL matches places within searchRadiusMiles of the location
+// given as $1 and $2.
+var withinRadiusSQL = fmt.Sprintf(`p.location <@> POINT($1, $2) < %g`, searchRadiusMiles)
+
+// rankingSQL scores a curated place between roughly -1 and 1 per weight. It
+// expects the weights as $3 to $6 and must be kept in step with
+// RankingWeights.score.
+var rankingSQL = fmt.Sprintf(`$3 * (1 - (p.location <@> POINT($1, $2)) / %g)
+		+ $4 * COALESCE(p.curator_score, 0) / 5
+		+ $5 * COALESCE(f.score, 0)::float / (1 + abs(COALESCE(f.score, 0)))
+		+ $6 * exp(-extract(epoch FROM now() - COALESCE(p.recommended_at, now())) / 86400 / %d)`,
+	searchRadiusMiles, freshnessDays)
+
+type RankingWeights struct {
+	Distance     float64
+	CuratorScore float64
+	Feedback     float64
+	Freshness    float64
+}
+
+type rankingInput struct {
+	DistanceMiles float64
+	CuratorScore  float64
+	FeedbackScore int
+	AgeDays       float64
+}
+
+func DefaultRankingWeights() RankingWeights {
+	return RankingWeights{
+		Distance:     1,
+		CuratorScore: 1,
+		Feedback:     1,
+		Freshness:    0.5,
+	}
+}
+
+// score mirrors rankingSQL so the weighting can be tested without Postgres.
+func (w RankingWeights) score(in rankingInput) float64 {
+	feedback := float64(in.FeedbackScore) / (1 + math.Abs(float64(in.FeedbackScore)))
+	return w.Distance*(1-in.DistanceMiles/searchRadiusMiles) +
+		w.CuratorScore*in.CuratorScore/5 +
+		w.Feedback*feedback +
+		w.Freshness*math.Exp(-in.AgeDays/freshnessDays)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var rankingFixtures = map[string]rankingInput{
+	"nearby, unrated":        {DistanceMiles: 0.02, CuratorScore: 0, FeedbackScore: 0, AgeDays: 400},
+	"far, curator favourite": {DistanceMiles: 0.28, CuratorScore: 5, FeedbackScore: 0, AgeDays: 400},
+	"mid, well liked":        {DistanceMiles: 0.15, CuratorScore: 3, FeedbackScore: 12, AgeDays: 30},
+	"mid, disliked":          {DistanceMiles: 0.15, CuratorScore: 3, FeedbackScore: -8, AgeDays: 30},
+	"new opening":            {DistanceMiles: 0.15, CuratorScore: 3, FeedbackScore: 0, AgeDays: 1},
+}
+
+func TestRankingOrder(t *testing.T) {
+	tests := []struct {
+		Weights  RankingWeights
+		Expected []string
+	}{
+		{
+			Weights: DefaultRankingWeights(),
+			Expected: []string{
+				"mid, well liked",
+				"new opening",
+				"far, curator favourite",
+				"nearby, unrated",
+				"mid, disliked",
+			},
+		},
+		{
+			Weights: RankingWeights{Distance: 1},
+			Expected: []string{
+				"nearby, unrated",
+				"mid, disliked",
+				"mid, well liked",
+				"new opening",
+				"far, curator favourite",
+			},
+		},
+		{
+			Weights: RankingWeights{Freshness: 1},
+			Expected: []string{
+				"new opening",
+				"mid, disliked",
+				"mid, well liked",
+				"far, curator favourite",
+				"nearby, unrated",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := rankFixtures(test.Weights)
+		if !reflect.DeepEqual(got, test.Expected) {
+			t.Errorf("expected order %v with weights %+v, got %v", test.Expected, test.Weights, got)
+		}
+	}
+}
+
+func rankFixtures(w RankingWeights) []string {
+	var names []string
+	for name := range rankingFixtures {
+		names = append(names, name)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		a, b := w.score(rankingFixtures[names[i]]), w.score(rankingFixtures[names[j]])
+		if a == b {
+			return names[i] < names[j]
+		}
+		return a > b
+	})
+	return names
+}
+
+// milesPerDegree is the length of a degree of latitude as earthdistance
+// measures it.
+const milesPerDegree = 3958.747 * math.Pi / 180
+
+// TestRankingSQL ranks the fixtures in Postgres, to check rankingSQL agrees
+// with RankingWeights.score.
+func TestRankingSQL(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	origin := Location{Latitude: 51.5, Longitude: -0.1}
+	cleanup := func() {
+		db.Exec(`DELETE FROM feedback WHERE googleid LIKE 'ranking-test:%';`)
+		db.Exec(`DELETE FROM places WHERE googleid LIKE 'ranking-test:%';`)
+	}
+	cleanup()
+	defer cleanup()
+
+	for name, in := range rankingFixtures {
+		id := "ranking-test:" + name
+		_, err := db.Exec(`INSERT INTO places (googleid, name, location, curator_score, recommended_at)
+			VALUES ($1, $2, POINT($3, $4), $5, now() - $6 * interval '1 day');`,
+			id, name, origin.Longitude, origin.Latitude+in.DistanceMiles/milesPerDegree, in.CuratorScore, in.AgeDays)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < abs(in.FeedbackScore); i++ {
+			score := 1
+			if in.FeedbackScore < 0 {
+				score = -1
+			}
+			_, err := db.Exec(`INSERT INTO feedback (googleid, psid, score) VALUES ($1, $2, $3);`, id, fmt.Sprint(i), score)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, weights := range []RankingWeights{DefaultRankingWeights(), {Distance: 1}, {Freshness: 1}} {
+		places, err := NewPlaceRepository(db).Nearby(origin, weights, SearchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range places {
+			if strings.HasPrefix(p.ID, "ranking-test:") {
+				got = append(got, p.Name)
+			}
+		}
+		if expected := rankFixtures(weights); !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected order %v with weights %+v, got %v", expected, weights, got)
+		}
+	}
+}
+
+func TestRankingSQLUsesConstants(t *testing.T) {
+	if !strings.Contains(withinRadiusSQL, fmt.Sprintf("< %g", searchRadiusMiles)) {
+		t.Errorf("expected the search radius in %q", withinRadiusSQL)
+	}
+	for _, want := range []string{fmt.Sprintf("/ %g)", searchRadiusMiles), fmt.Sprintf("/ 86400 / %d)", freshnessDays)} {
+		if !strings.Contains(rankingSQL, want) {
+			t.Errorf("expected %q in %q", want, rankingSQL)
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
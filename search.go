@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pendingSearchTTL is how long we wait for a location after being asked
+	// for something specific, like somewhere open later.
+	pendingSearchTTL = 30 * time.Minute
+	// lastSearchTTL is how long "Cheaper options" can rerun a search for.
+	lastSearchTTL   = time.Hour
+	cheaperPayload  = "CHEAPER"
+	cheapPriceLevel = 1
+)
+
+var (
+	cheapWords = []string{"cheap", "budget", "inexpensive"}
+	// fillerWords don't make a message a free-text query on their own.
+	fillerWords = wordSet(`hi hello hey there please thanks thank you i im i'm me my we us want wanna would like need
+		find show get give a an the some somewhere something anywhere place places good great nice best food eat eating
+		hungry open now later today tonight at am pm near nearby around here in is are any can could recommend
+		recommendation recommendations for to of on and or with what where whats what's eats meal dinner lunch
+		breakfast bite grub quick`)
+	// foodWords show a message is asking for something to eat, rather than
+	// just being chat like "ok" or "yes please".
+	foodWords = wordSet(`food eat eats eating meal dinner lunch breakfast brunch supper bite snack grub hungry
+		vegan vegetarian veggie halal kosher gluten dessert
+		pizza burger ramen sushi dumpling noodle curry taco tapas pho kebab falafel steak seafood fish chips
+		chicken salad sandwich bagel pasta dim sum bbq barbecue ice cream
+		thai chinese indian italian japanese korean mexican vietnamese french spanish greek turkish lebanese
+		ethiopian peruvian`)
+	wordPattern = regexp.MustCompile(`[\p{L}']+`)
+	// areaPattern finds an area named in a query, e.g. "in Soho".
+	areaPattern = regexp.MustCompile(`(?i)\b(?:in|near|around)\s+([^\d,.!?]+?)\s*(?:\b(?:at|tonight|open|for)\b|[\d,.!?]|$)`)
+)
+
+// SearchRequest is what the user asked for, beyond somewhere open near them.
+type SearchRequest struct {
+	// Target is set when the user wants somewhere open later.
+	Target *TargetTime
+	// MaxPrice is the most expensive Google price level (1 to 4) to offer,
+	// or 0 for any.
+	MaxPrice int
+	// Type is the Google place type wanted, restaurant if unset.
+	Type string
+	// Query is set when the user asked for something specific, like
+	// "best dumplings in Soho", and is searched for as free text.
+	Query string
+	// Area is where Query should be searched, if the user named somewhere.
+	Area string
+}
+
+// lastSearch is a search we've answered, kept so it can be rerun.
+type lastSearch struct {
+	Request  SearchRequest
+	Location Location
+	// CheapestShown is the lowest price level among the places we sent.
+	CheapestShown int
+}
+
+type expiringSearch struct {
+	Search  lastSearch
+	Expires time.Time
+}
+
+type searchStore struct {
+	sync.Mutex
+	m map[string]expiringSearch
+}
+
+func newSearchStore() *searchStore {
+	return &searchStore{m: make(map[string]expiringSearch)}
+}
+
+func (s *searchStore) put(user string, search lastSearch, ttl time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.m[user] = expiringSearch{Search: search, Expires: time.Now().Add(ttl)}
+}
+
+func (s *searchStore) get(user string) (lastSearch, bool) {
+	return s.lookup(user, false)
+}
+
+// take is get, but forgets the search too.
+func (s *searchStore) take(user string) (lastSearch, bool) {
+	return s.lookup(user, true)
+}
+
+func (s *searchStore) lookup(user string, remove bool) (lastSearch, bool) {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.m[user]
+	if remove || (ok && time.Now().After(e.Expires)) {
+		delete(s.m, user)
+	}
+	if !ok || time.Now().After(e.Expires) {
+		return lastSearch{}, false
+	}
+	return e.Search, true
+}
+
+// parseSearchRequest picks out a time to be open, whether the user wants
+// somewhere cheap, what kind of place and anything else they asked for from
+// a text message.
+func parseSearchRequest(categories []PlaceCategory, text string) (SearchRequest, bool) {
+	var req SearchRequest
+	found := false
+	if target, ok := parseTargetTime(text); ok {
+		req.Target = &target
+		found = true
+	}
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		if isCheapWord(word) {
+			req.MaxPrice = cheapPriceLevel
+			found = true
+			break
+		}
+	}
+	if placeType, ok := parsePlaceType(categories, text); ok {
+		req.Type = placeType
+		found = true
+	}
+	if isFreeTextQuery(categories, text) {
+		req.Query = strings.TrimSpace(text)
+		if m := areaPattern.FindStringSubmatch(text); m != nil && !strings.EqualFold(m[1], "me") && !strings.EqualFold(m[1], "here") {
+			req.Area = strings.TrimSpace(m[1])
+		}
+		found = true
+	}
+	return req, found
+}
+
+func (req SearchRequest) String() string {
+	var parts []string
+	if req.MaxPrice != 0 {
+		parts = append(parts, "cheap")
+	}
+	if req.Target != nil {
+		parts = append(parts, "open "+req.Target.String())
+	} else {
+		parts = append(parts, "open now")
+	}
+	if req.Query != "" {
+		return fmt.Sprintf("for %q that's %s", req.Query, strings.Join(parts, " and "))
+	}
+	if category, ok := findCategory(placeCategories, req.Type); ok && req.Type != defaultPlaceType {
+		return category.Noun + " that's " + strings.Join(parts, " and ")
+	}
+	return strings.Join(parts, " and ")
+}
+
+// isFreeTextQuery reports whether text asks for food or a kind of place and
+// more than we understand from parseSearchRequest, so should be searched for
+// as a query.
+func isFreeTextQuery(categories []PlaceCategory, text string) bool {
+	specific, food := false, false
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		if _, ok := parsePlaceType(categories, word); ok {
+			food = true
+			continue
+		}
+		if foodWords[word] || foodWords[strings.TrimSuffix(word, "s")] {
+			food = true
+		}
+		if !fillerWords[word] && !isCheapWord(word) {
+			specific = true
+		}
+	}
+	return specific && food
+}
+
+// queryWords returns the lower case words of text that aren't filler.
+func queryWords(text string) []string {
+	var words []string
+	for _, word := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+		if !fillerWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func isCheapWord(word string) bool {
+	for _, cheap := range cheapWords {
+		if word == cheap {
+			return true
+		}
+	}
+	return false
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// matchesQuery reports whether a curated place's name, cuisines, note or
+// recommended dish mention any word of query.
+func (p *Place) matchesQuery(query string) bool {
+	text := strings.ToLower(strings.Join(append([]string{p.Name, p.Note, p.RecommendedDish}, p.Cuisines...), " "))
+	for _, word := range queryWords(query) {
+		if strings.Contains(text, strings.TrimSuffix(word, "s")) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchingQuery(places []Place, query string) []Place {
+	var matching []Place
+	for _, p := range places {
+		if p.matchesQuery(query) {
+			matching = append(matching, p)
+		}
+	}
+	return matching
+}
+
+// cheaperRequest lowers the price cap below the cheapest place already shown.
+func (s lastSearch) cheaperRequest() (SearchRequest, bool) {
+	req := s.Request
+	max := s.CheapestShown - 1
+	if req.MaxPrice != 0 && req.MaxPrice-1 < max {
+		max = req.MaxPrice - 1
+	}
+	if max < cheapPriceLevel {
+		return req, false
+	}
+	req.MaxPrice = max
+	return req, true
+}
+
+// cheapestPriceLevel returns the lowest known price level of places, or 0 if
+// none are known.
+func cheapestPriceLevel(places []Place) int {
+	cheapest := 0
+	for _, p := range places {
+		if p.PriceLevel > 0 && (cheapest == 0 || p.PriceLevel < cheapest) {
+			cheapest = p.PriceLevel
+		}
+	}
+	return cheapest
+}
+
+// PriceLabel shows a place's price level as £ to ££££.
+func (p *Place) PriceLabel() string {
+	return strings.Repeat("£", p.PriceLevel)
+}
+
+func (app *App) handleCheaperOptions(FBUserID string) {
+	search, ok := app.lastSearches.get(FBUserID)
+	if !ok {
+		app.sendLocationPrompt(FBUserID, "Send me your location and I'll find somewhere cheap.")
+		app.pendingSearches.put(FBUserID, lastSearch{Request: SearchRequest{MaxPrice: cheapPriceLevel}}, pendingSearchTTL)
+		return
+	}
+	req, ok := search.cheaperRequest()
+	if !ok {
+		app.sendText(FBUserID, "Those are already the cheapest places I know around here!")
+		return
+	}
+	defer app.showTyping(FBUserID)()
+	app.sendText(FBUserID, fmt.Sprintf("Looking for somewhere %s...", req))
+	app.recommend(FBUserID, search.Location, req)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseSearchRequest(t *testing.T) {
+	tests := []struct {
+		Text     string
+		MaxPrice int
+		Target   *TargetTime
+		Found    bool
+	}{
+		{Text: "cheap eats please", MaxPrice: 1, Found: true},
+		{Text: "somewhere on a budget at 8pm", MaxPrice: 1, Target: &TargetTime{Hour: 20}, Found: true},
+		{Text: "tonight", Target: &TargetTime{Hour: 20, Tonight: true}, Found: true},
+		{Text: "hi there"},
+		{Text: "lunch near Cheapside", Found: true},
+		{Text: "what about budgets"},
+	}
+
+	for _, test := range tests {
+		got, found := parseSearchRequest(placeCategories, test.Text)
+		if found != test.Found || got.MaxPrice != test.MaxPrice {
+			t.Errorf("expected max price %d, %t for %q, got %d, %t", test.MaxPrice, test.Found, test.Text, got.MaxPrice, found)
+		}
+		if (got.Target == nil) != (test.Target == nil) || (got.Target != nil && *got.Target != *test.Target) {
+			t.Errorf("expected target %v for %q, got %v", test.Target, test.Text, got.Target)
+		}
+	}
+}
+
+func TestParseSearchRequestQuery(t *testing.T) {
+	tests := []struct {
+		Text  string
+		Query string
+		Area  string
+	}{
+		{Text: "best dumplings in Soho", Query: "best dumplings in Soho", Area: "Soho"},
+		{Text: "ramen near Kings Cross at 8pm", Query: "ramen near Kings Cross at 8pm", Area: "Kings Cross"},
+		{Text: "vegan pizza near me", Query: "vegan pizza near me"},
+		{Text: "cheap eats please"},
+		{Text: "drinks tonight"},
+		{Text: "coffee near me"},
+		{Text: "thai food", Query: "thai food"},
+		{Text: "ok"},
+		{Text: "thanks"},
+		{Text: "yes please"},
+		{Text: "see you in Soho"},
+	}
+
+	for _, test := range tests {
+		got, _ := parseSearchRequest(placeCategories, test.Text)
+		if got.Query != test.Query || got.Area != test.Area {
+			t.Errorf("expected query %q in %q for %q, got %q in %q", test.Query, test.Area, test.Text, got.Query, got.Area)
+		}
+	}
+}
+
+func TestMatchesQuery(t *testing.T) {
+	place := Place{Name: "Dumpling Shack", Cuisines: []string{"chinese"}}
+	if !place.matchesQuery("best dumplings in Soho") {
+		t.Errorf("expected %v to match dumplings", place)
+	}
+	if place.matchesQuery("vegan pizza") {
+		t.Errorf("expected %v not to match pizza", place)
+	}
+}
+
+func TestCheaperRequest(t *testing.T) {
+	tests := []struct {
+		Search   lastSearch
+		MaxPrice int
+		Cheaper  bool
+	}{
+		{Search: lastSearch{CheapestShown: 3}, MaxPrice: 2, Cheaper: true},
+		{Search: lastSearch{Request: SearchRequest{MaxPrice: 2}, CheapestShown: 4}, MaxPrice: 1, Cheaper: true},
+		{Search: lastSearch{CheapestShown: 1}},
+		{Search: lastSearch{}},
+	}
+
+	for _, test := range tests {
+		got, ok := test.Search.cheaperRequest()
+		if ok != test.Cheaper || (ok && got.MaxPrice != test.MaxPrice) {
+			t.Errorf("expected max price %d, %t for %+v, got %d, %t", test.MaxPrice, test.Cheaper, test.Search, got.MaxPrice, ok)
+		}
+	}
+}
+
+func TestSearchStore(t *testing.T) {
+	store := &searchStore{m: make(map[string]expiringSearch)}
+	store.put("1234", lastSearch{Request: SearchRequest{MaxPrice: 2}}, pendingSearchTTL)
+
+	if got, ok := store.get("1234"); !ok || got.Request.MaxPrice != 2 {
+		t.Errorf("expected stored search, got %v, %t", got, ok)
+	}
+	if _, ok := store.take("1234"); !ok {
+		t.Errorf("expected to take stored search")
+	}
+	if _, ok := store.get("1234"); ok {
+		t.Errorf("expected search to be forgotten once taken")
+	}
+
+	store.put("1234", lastSearch{}, -1)
+	if _, ok := store.get("1234"); ok {
+		t.Errorf("expected expired search to be ignored")
+	}
+}
+
+func TestSearchNearbyPriceLevels(t *testing.T) {
+	googleServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("maxprice") != "2" || query.Get("minprice") != "" || query.Get("opennow") != "true" {
+				t.Errorf("unexpected search parameters %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(GooglePlacesSearchResponse{
+				Results: []Place{{ID: "a", Name: "Bar Marsella", PriceLevel: 2}},
+			})
+		}),
+	)
+	defer googleServer.Close()
+	client := GooglePlacesClient{BaseURL: googleServer.URL}
+
+	places, err := client.Nearby(Location{}, NearbySearch{OpenNow: true, Limit: 3, MaxPrice: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(places) != 1 || places[0].PriceLevel != 2 {
+		t.Fatalf("expected price level to be decoded, got %v", places)
+	}
+	if got := places[0].Subtitle(); got != "££" {
+		t.Errorf("expected subtitle ££, got %q", got)
+	}
+}
+
+func TestSearchRequestString(t *testing.T) {
+	tests := []struct {
+		Request  SearchRequest
+		Expected string
+	}{
+		{Request: SearchRequest{}, Expected: "open now"},
+		{Request: SearchRequest{Type: "restaurant", MaxPrice: 1}, Expected: "cheap and open now"},
+		{Request: SearchRequest{Type: "cafe", Target: &TargetTime{Tonight: true}}, Expected: "for coffee that's open tonight"},
+	}
+
+	for _, test := range tests {
+		if got := test.Request.String(); got != test.Expected {
+			t.Errorf("expected %q, got %q", test.Expected, got)
+		}
+	}
+}
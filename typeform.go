@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultTypeformRestaurantRef = "restaurant"
+	defaultTypeformAreaRef       = "area"
+	errInvalidSignature          = "invalid typeform signature"
+	errNoRestaurant              = "no restaurant named in typeform response"
+)
+
+type TypeformWebhook struct {
+	EventID      string           `json:"event_id"`
+	EventType    string           `json:"event_type"`
+	FormResponse TypeformResponse `json:"form_response"`
+}
+
+type TypeformResponse struct {
+	FormID  string           `json:"form_id"`
+	Token   string           `json:"token"`
+	Answers []TypeformAnswer `json:"answers"`
+}
+
+type TypeformAnswer struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Field struct {
+		ID  string `json:"id"`
+		Ref string `json:"ref"`
+	} `json:"field"`
+}
+
+// TypeformWebhookHandler receives "Make a recommendation" submissions, looks
+// the restaurant up on Google and stores it as a pending curated place.
+func (app *App) TypeformWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !verifyTypeformSignature(body, r.Header.Get("Typeform-Signature"), app.Config.TypeformSecret) {
+		http.Error(w, errInvalidSignature, http.StatusUnauthorized)
+		return
+	}
+
+	var webhook TypeformWebhook
+	err = json.Unmarshal(body, &webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, err := webhook.FormResponse.restaurantQuery(app.Config.TypeformRestaurantRef, app.Config.TypeformAreaRef)
+	if err != nil {
+		// Typeform retries anything but a 2xx, which won't help here.
+		log.Printf("ignoring typeform response %s: %s", webhook.FormResponse.Token, err)
+		return
+	}
+
+	places, err := app.Places.TextSearch(TextQuery{Query: query, Limit: 1})
+	if err != nil {
+		log.Println("error searching google for recommendation: ", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if len(places) == 0 {
+		log.Printf("no google results for recommendation %q", query)
+		return
+	}
+
+	// Curated places are keyed by Google place ID, so a result from a
+	// fallback provider can't be stored.
+	if providerName(places[0].ID) != providerGoogle {
+		log.Printf("ignoring %s result %s for recommendation %q", providerName(places[0].ID), places[0].ID, query)
+		return
+	}
+
+	err = NewPlaceRepository(app.DB).InsertPending(places[0], "typeform:"+webhook.FormResponse.Token)
+	if err != nil {
+		log.Println("error saving recommendation: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// verifyTypeformSignature checks the Typeform-Signature header, which is
+// "sha256=" followed by the base64 HMAC-SHA256 of the body.
+func verifyTypeformSignature(body []byte, signature, secret string) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(strings.TrimPrefix(signature, "sha256=")), []byte(expected))
+}
+
+// restaurantQuery builds a Google text search from the answers to the
+// restaurant name and, if given, area questions.
+func (r TypeformResponse) restaurantQuery(restaurantRef, areaRef string) (string, error) {
+	name := r.answer(restaurantRef)
+	if name == "" {
+		return "", errors.New(errNoRestaurant)
+	}
+	if area := r.answer(areaRef); area != "" {
+		return name + " " + area, nil
+	}
+	return name, nil
+}
+
+func (r TypeformResponse) answer(ref string) string {
+	for _, a := range r.Answers {
+		if a.Field.Ref == ref {
+			return strings.TrimSpace(a.Text)
+		}
+	}
+	return ""
+}
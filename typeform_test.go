@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const typeformFixture = `{
+	"event_id": "LtWXD3crgy",
+	"event_type": "form_response",
+	"form_response": {
+		"form_id": "noVPUi",
+		"token": "a3a12ec67a1365927098a606107fac15",
+		"answers": [
+			{"type": "text", "text": " Bar Marsella ", "field": {"id": "JwWggjAKtOkA", "ref": "restaurant"}},
+			{"type": "text", "text": "El Raval, Barcelona", "field": {"id": "SMEUb7VJz92Q", "ref": "area"}},
+			{"type": "text", "text": "Absinthe!", "field": {"id": "pn48RmPazVdM", "ref": "why"}}
+		]
+	}
+}`
+
+func TestVerifyTypeformSignature(t *testing.T) {
+	body := []byte(typeformFixture)
+	tests := []struct {
+		Signature string
+		Secret    string
+		Expected  bool
+	}{
+		{
+			Signature: "sha256=" + signTypeform(body, "s3cret"),
+			Secret:    "s3cret",
+			Expected:  true,
+		},
+		{
+			Signature: "sha256=" + signTypeform(body, "wrong"),
+			Secret:    "s3cret",
+		},
+		{
+			Signature: signTypeform(body, "s3cret"),
+			Secret:    "s3cret",
+		},
+		{
+			Signature: "sha256=" + signTypeform(body, ""),
+			Secret:    "",
+		},
+	}
+
+	for _, test := range tests {
+		got := verifyTypeformSignature(body, test.Signature, test.Secret)
+		if got != test.Expected {
+			t.Errorf("expected %t for signature %s, got %t", test.Expected, test.Signature, got)
+		}
+	}
+}
+
+func TestTypeformRestaurantQuery(t *testing.T) {
+	var webhook TypeformWebhook
+	if err := json.Unmarshal([]byte(typeformFixture), &webhook); err != nil {
+		t.Fatalf("unexpected error decoding fixture: %s", err)
+	}
+
+	got, err := webhook.FormResponse.restaurantQuery(defaultTypeformRestaurantRef, defaultTypeformAreaRef)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "Bar Marsella El Raval, Barcelona"
+	if got != expected {
+		t.Errorf("expected query %q, got %q", expected, got)
+	}
+
+	_, err = TypeformResponse{}.restaurantQuery(defaultTypeformRestaurantRef, defaultTypeformAreaRef)
+	if err == nil || err.Error() != errNoRestaurant {
+		t.Errorf("expected %q error for empty response, got %v", errNoRestaurant, err)
+	}
+}
+
+func TestTypeformWebhookHandler(t *testing.T) {
+	tests := []struct {
+		Place    Place
+		Affected int64
+		Expected []string
+	}{
+		{
+			Place:    Place{ID: "ChIJ5aE1", Name: "Bar Marsella"},
+			Affected: 1,
+			Expected: []string{"INSERT INTO places", "INSERT INTO place_audit"},
+		},
+		{
+			Place:    Place{ID: "ChIJ5aE1", Name: "Bar Marsella"},
+			Expected: []string{"INSERT INTO places"},
+		},
+		{
+			Place: Place{ID: "osm:node/123", Name: "Bar Marsella"},
+		},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t)
+		fake.Affected = []int64{test.Affected}
+		app := newApp(Config{TypeformSecret: "s3cret", TypeformRestaurantRef: defaultTypeformRestaurantRef}, db, stubProvider{Places: []Place{test.Place}}, &fakeSender{})
+		req := httptest.NewRequest("POST", "/typeform", strings.NewReader(typeformFixture))
+		req.Header.Set("Typeform-Signature", "sha256="+signTypeform([]byte(typeformFixture), "s3cret"))
+		w := httptest.NewRecorder()
+
+		app.TypeformWebhookHandler(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status %d for %s, got %d", http.StatusOK, test.Place.ID, w.Code)
+		}
+		queries := fake.Queries()
+		if len(queries) != len(test.Expected) {
+			t.Errorf("expected %v for %s, got %v", test.Expected, test.Place.ID, queries)
+			continue
+		}
+		for i, prefix := range test.Expected {
+			if !strings.HasPrefix(queries[i], prefix) {
+				t.Errorf("expected %s for %s, got %s", prefix, test.Place.ID, queries[i])
+			}
+		}
+		if len(queries) == 2 {
+			audit := fake.Last()
+			if audit.Args[1] != "typeform:a3a12ec67a1365927098a606107fac15" || audit.Args[2] != "submit" {
+				t.Errorf("expected the submission to be audited, got %v", audit)
+			}
+		}
+	}
+}
+
+func signTypeform(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// User is someone who has talked to the bot, identified by their page-scoped
+// ID, and what they've told us they like.
+type User struct {
+	PSID string
+	// FirstSeen is when the user first messaged us.
+	FirstSeen time.Time
+	// Onboarded is set once the user has been welcomed and asked about their
+	// dietary requirements.
+	Onboarded bool
+	// Locale is the user's Messenger locale, e.g. en_GB, if we know it.
+	Locale string
+	// Dietary holds flags from dietaryFlags that places must meet.
+	Dietary []string
+	// Budget is the most expensive price level (1 to 4) to offer, or 0 for
+	// any.
+	Budget int
+	// Cuisines are favourites, offered ahead of other places.
+	Cuisines []string
+	// Blocked is set when Messenger tells us the user has blocked the page,
+	// and cleared when they next message us.
+	Blocked bool
+}
+
+type UserStore interface {
+	// Get returns the user, or a new User if we haven't seen them before.
+	Get(psid string) (User, error)
+	Save(user User) error
+	// Seen records a message from the user, creating them the first time
+	// and clearing Blocked, as only users who haven't blocked the page can
+	// message us.
+	Seen(psid string) error
+	SetBlocked(psid string, blocked bool) error
+}
+
+// UserRepository stores users in Postgres.
+type UserRepository struct {
+	DB *sql.DB
+}
+
+func NewUserRepository(DB *sql.DB) UserRepository {
+	return UserRepository{DB: DB}
+}
+
+func (repo UserRepository) Get(psid string) (User, error) {
+	user := User{PSID: psid}
+	err := repo.DB.QueryRow(`SELECT first_seen, onboarded, locale, dietary, budget, cuisines, blocked FROM users WHERE psid = $1;`, psid).
+		Scan(&user.FirstSeen, &user.Onboarded, &user.Locale, pq.Array(&user.Dietary), &user.Budget, pq.Array(&user.Cuisines), &user.Blocked)
+	if err == sql.ErrNoRows {
+		return User{PSID: psid}, nil
+	}
+	return user, err
+}
+
+// Save creates or updates the user's profile.
+func (repo UserRepository) Save(user User) error {
+	_, err := repo.DB.Exec(`INSERT INTO users (psid, onboarded, locale, dietary, budget, cuisines) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (psid) DO UPDATE SET onboarded = EXCLUDED.onboarded, locale = EXCLUDED.locale,
+			dietary = EXCLUDED.dietary, budget = EXCLUDED.budget, cuisines = EXCLUDED.cuisines;`,
+		user.PSID, user.Onboarded, user.Locale, pq.Array(nonNil(user.Dietary)), user.Budget, pq.Array(nonNil(user.Cuisines)))
+	return err
+}
+
+// Seen creates the user, setting first_seen, or unblocks them. Users who
+// aren't blocked aren't written to again.
+func (repo UserRepository) Seen(psid string) error {
+	_, err := repo.DB.Exec(`INSERT INTO users (psid) VALUES ($1)
+		ON CONFLICT (psid) DO UPDATE SET blocked = false WHERE users.blocked;`, psid)
+	return err
+}
+
+// SetBlocked records whether the user has blocked the page.
+func (repo UserRepository) SetBlocked(psid string, blocked bool) error {
+	_, err := repo.DB.Exec(`INSERT INTO users (psid, blocked) VALUES ($1, $2)
+		ON CONFLICT (psid) DO UPDATE SET blocked = EXCLUDED.blocked;`, psid, blocked)
+	return err
+}
+
+// searchRequest applies the user's budget to req, unless it already has a
+// price limit, such as one from asking for cheaper options.
+func (u User) searchRequest(req SearchRequest) SearchRequest {
+	if req.MaxPrice == 0 {
+		req.MaxPrice = u.Budget
+	}
+	return req
+}
+
+// preferCuisines moves places serving any of the user's favourite cuisines to
+// the front, otherwise keeping their order.
+func (u User) preferCuisines(places []Place) []Place {
+	if len(u.Cuisines) == 0 {
+		return places
+	}
+	var favourite, other []Place
+	for _, p := range places {
+		if servesAny(p, u.Cuisines) {
+			favourite = append(favourite, p)
+		} else {
+			other = append(other, p)
+		}
+	}
+	return append(favourite, other...)
+}
+
+func servesAny(p Place, cuisines []string) bool {
+	for _, c := range p.Cuisines {
+		if containsString(cuisines, c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestUserSearchRequest(t *testing.T) {
+	tests := []struct {
+		Budget   int
+		MaxPrice int
+		Expected int
+	}{
+		{Budget: 0, MaxPrice: 0, Expected: 0},
+		{Budget: 2, MaxPrice: 0, Expected: 2},
+		{Budget: 2, MaxPrice: 1, Expected: 1},
+	}
+
+	for _, test := range tests {
+		user := User{Budget: test.Budget}
+		req := user.searchRequest(SearchRequest{MaxPrice: test.MaxPrice, Type: "cafe"})
+		if req.MaxPrice != test.Expected || req.Type != "cafe" {
+			t.Errorf("expected max price %d with budget %d, got %+v", test.Expected, test.Budget, req)
+		}
+	}
+}
+
+func TestUserPreferCuisines(t *testing.T) {
+	places := []Place{
+		{ID: "1", Cuisines: []string{"british"}},
+		{ID: "2", Cuisines: []string{"thai"}},
+		{ID: "3"},
+		{ID: "4", Cuisines: []string{"italian", "pizza"}},
+	}
+
+	var got []string
+	for _, p := range (User{Cuisines: []string{"italian", "thai"}}).preferCuisines(places) {
+		got = append(got, p.ID)
+	}
+	if expected := []string{"2", "4", "1", "3"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if unchanged := (User{}).preferCuisines(places); !reflect.DeepEqual(unchanged, places) {
+		t.Errorf("expected places unchanged without favourites, got %v", unchanged)
+	}
+}
+
+func TestUserRepositoryWrites(t *testing.T) {
+	tests := []struct {
+		Write    func(UserRepository) error
+		Expected string
+		Args     []driver.Value
+	}{
+		{
+			Write:    func(repo UserRepository) error { return repo.Seen("1234") },
+			Expected: "INSERT INTO users (psid) VALUES ($1) ON CONFLICT (psid) DO UPDATE SET blocked = false WHERE users.blocked;",
+			Args:     []driver.Value{"1234"},
+		},
+		{
+			Write:    func(repo UserRepository) error { return repo.SetBlocked("1234", true) },
+			Expected: "INSERT INTO users (psid, blocked) VALUES ($1, $2) ON CONFLICT (psid) DO UPDATE SET blocked = EXCLUDED.blocked;",
+			Args:     []driver.Value{"1234", true},
+		},
+		{
+			Write: func(repo UserRepository) error {
+				return repo.Save(User{PSID: "1234", Onboarded: true, Locale: "en_GB", Budget: 2})
+			},
+			Expected: "INSERT INTO users (psid, onboarded, locale, dietary, budget, cuisines) VALUES ($1, $2, $3, $4, $5, $6) " +
+				"ON CONFLICT (psid) DO UPDATE SET onboarded = EXCLUDED.onboarded, locale = EXCLUDED.locale, " +
+				"dietary = EXCLUDED.dietary, budget = EXCLUDED.budget, cuisines = EXCLUDED.cuisines;",
+			Args: []driver.Value{"1234", true, "en_GB", "{}", int64(2), "{}"},
+		},
+	}
+
+	for _, test := range tests {
+		db, fake := newFakeDB(t)
+		if err := test.Write(NewUserRepository(db)); err != nil {
+			t.Fatal(err)
+		}
+		if last := fake.Last(); last.Query != test.Expected || !reflect.DeepEqual(last.Args, test.Args) {
+			t.Errorf("expected %q with %v, got %v", test.Expected, test.Args, last)
+		}
+	}
+}
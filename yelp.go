@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const errYelpNeedsLocation = "yelp searches need a location"
+
+// yelpCategories maps Google place types to Yelp category aliases. Yelp has
+// no takeaway category, so takeaways are searched for as restaurants.
+var yelpCategories = map[string]string{
+	"restaurant": "restaurants",
+	"cafe":       "coffee",
+	"bar":        "bars",
+	"bakery":     "bakeries",
+}
+
+// YelpClient talks to the Yelp Fusion API. Yelp gives opening hours in the
+// business's local time without saying which zone that is, so they're taken
+// to be in Timezone.
+type YelpClient struct {
+	BaseURL  string
+	APIKey   string
+	Timezone string
+}
+
+type yelpSearchResponse struct {
+	Businesses []yelpBusiness `json:"businesses"`
+}
+
+type yelpBusiness struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Coordinates struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"coordinates"`
+	// Price is "$" to "$$$$".
+	Price      string   `json:"price"`
+	Rating     float64  `json:"rating"`
+	URL        string   `json:"url"`
+	ImageURL   string   `json:"image_url"`
+	Photos     []string `json:"photos"`
+	Categories []struct {
+		Alias string `json:"alias"`
+	} `json:"categories"`
+	Hours []struct {
+		Open []struct {
+			// Day is 0 for Monday to 6 for Sunday.
+			Day   int    `json:"day"`
+			Start string `json:"start"`
+			End   string `json:"end"`
+		} `json:"open"`
+	} `json:"hours"`
+}
+
+func NewYelpClient(c Config) YelpClient {
+	return YelpClient{
+		BaseURL:  "https://api.yelp.com/v3",
+		APIKey:   c.YelpAPIKey,
+		Timezone: c.PlacesTimezone,
+	}
+}
+
+func (client YelpClient) Nearby(l Location, search NearbySearch) ([]Place, error) {
+	category, ok := yelpCategories[search.Type]
+	if !ok {
+		category = yelpCategories[defaultPlaceType]
+	}
+	params := url.Values{}
+	params.Set("latitude", fmt.Sprint(l.Latitude))
+	params.Set("longitude", fmt.Sprint(l.Longitude))
+	params.Set("radius", "500")
+	params.Set("categories", category)
+	setYelpFilters(params, search.OpenNow, search.MinPrice, search.MaxPrice, search.Limit)
+	return client.search(params)
+}
+
+// TextSearch fails without a location to search around, as Yelp always needs
+// one, so another provider can be tried.
+func (client YelpClient) TextSearch(q TextQuery) ([]Place, error) {
+	if q.Location == nil {
+		return nil, errors.New(errYelpNeedsLocation)
+	}
+	radius := q.Radius
+	if radius == 0 {
+		radius = textSearchRadius
+	}
+	params := url.Values{}
+	params.Set("term", q.Query)
+	params.Set("latitude", fmt.Sprint(q.Location.Latitude))
+	params.Set("longitude", fmt.Sprint(q.Location.Longitude))
+	params.Set("radius", strconv.Itoa(radius))
+	if category, ok := yelpCategories[q.Type]; ok {
+		params.Set("categories", category)
+	}
+	setYelpFilters(params, q.OpenNow, q.MinPrice, q.MaxPrice, q.Limit)
+	return client.search(params)
+}
+
+func setYelpFilters(params url.Values, openNow bool, minPrice, maxPrice, limit int) {
+	if openNow {
+		params.Set("open_now", "true")
+	}
+	if minPrice > 0 || maxPrice > 0 {
+		if minPrice == 0 {
+			minPrice = 1
+		}
+		if maxPrice == 0 {
+			maxPrice = 4
+		}
+		var prices []string
+		for price := minPrice; price <= maxPrice; price++ {
+			prices = append(prices, strconv.Itoa(price))
+		}
+		params.Set("price", strings.Join(prices, ","))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+}
+
+func (client YelpClient) search(params url.Values) ([]Place, error) {
+	var resp yelpSearchResponse
+	if err := getJSON(client.BaseURL+"/businesses/search?"+params.Encode(), client.header(), &resp); err != nil {
+		return nil, err
+	}
+	var p []Place
+	for _, business := range resp.Businesses {
+		p = append(p, business.place(client.Timezone))
+	}
+	return p, nil
+}
+
+func (client YelpClient) Details(placeID string) (Place, error) {
+	id := strings.TrimPrefix(placeID, providerIDPrefixes[providerYelp])
+	var business yelpBusiness
+	if err := getJSON(client.BaseURL+"/businesses/"+url.PathEscape(id), client.header(), &business); err != nil {
+		return Place{}, err
+	}
+	return business.place(client.Timezone), nil
+}
+
+// Photo returns the photo's URL as it is; Yelp doesn't size photos.
+func (client YelpClient) Photo(reference string, maxWidth int) (string, error) {
+	return strings.TrimPrefix(reference, providerIDPrefixes[providerYelp]), nil
+}
+
+func (client YelpClient) header() http.Header {
+	return http.Header{"Authorization": {"Bearer " + client.APIKey}}
+}
+
+// place converts a Yelp business, whose hours are in timezone.
+func (business yelpBusiness) place(timezone string) Place {
+	prefix := providerIDPrefixes[providerYelp]
+	place := Place{
+		ID:         prefix + business.ID,
+		Name:       business.Name,
+		Website:    business.URL,
+		Rating:     business.Rating,
+		Location:   Location{Latitude: business.Coordinates.Latitude, Longitude: business.Coordinates.Longitude},
+		PriceLevel: utf8.RuneCountInString(business.Price),
+		Timezone:   timezone,
+	}
+	for _, c := range business.Categories {
+		for placeType, alias := range yelpCategories {
+			if c.Alias == alias {
+				place.Types = append(place.Types, placeType)
+			}
+		}
+	}
+	photos := business.Photos
+	if len(photos) == 0 && business.ImageURL != "" {
+		photos = []string{business.ImageURL}
+	}
+	for _, photo := range photos {
+		place.Photos = append(place.Photos, PlacePhoto{Reference: prefix + photo})
+	}
+	if len(business.Hours) > 0 {
+		place.OpeningHours = &OpeningHours{}
+		for _, h := range business.Hours[0].Open {
+			if period, ok := dailyPeriod((h.Day+1)%7, h.Start, h.End); ok {
+				place.OpeningHours.Periods = append(place.OpeningHours.Periods, period)
+			}
+		}
+	}
+	return place
+}
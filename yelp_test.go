@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const yelpBusinessFixture = `{
+  "id": "bar-marsella-barcelona",
+  "name": "Bar Marsella",
+  "coordinates": {"latitude": 41.3782, "longitude": 2.1718},
+  "price": "$$",
+  "rating": 4.5,
+  "url": "https://www.yelp.com/biz/bar-marsella-barcelona",
+  "photos": ["https://s3-media.fl.yelpcdn.com/bphoto/abc/o.jpg"],
+  "categories": [{"alias": "bars", "title": "Bars"}],
+  "hours": [{"open": [{"day": 0, "start": "2200", "end": "0230", "is_overnight": true}], "is_open_now": false}]
+}`
+
+func TestYelpTextSearchAndDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/businesses/search":
+			query := r.URL.Query()
+			if query.Get("term") != "absinthe" || query.Get("latitude") != "41.38" || query.Get("location") != "" || query.Get("price") != "1,2" {
+				t.Errorf("unexpected search parameters %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"businesses": [` + yelpBusinessFixture + `], "total": 1}`))
+		case "/businesses/bar-marsella-barcelona":
+			w.Write([]byte(yelpBusinessFixture))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := YelpClient{BaseURL: server.URL, APIKey: "key", Timezone: "Europe/Madrid"}
+
+	if _, err := client.TextSearch(TextQuery{Query: "absinthe"}); err == nil || err.Error() != errYelpNeedsLocation {
+		t.Errorf("expected searching without a location to fail, got %v", err)
+	}
+	places, err := client.TextSearch(TextQuery{Query: "absinthe", Location: &Location{Latitude: 41.38, Longitude: 2.17}, MaxPrice: 2})
+	if err != nil || len(places) != 1 {
+		t.Fatalf("expected one place, got %v, %v", places, err)
+	}
+	details, err := client.Details(places[0].ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := Place{
+		ID:         "yelp:bar-marsella-barcelona",
+		Name:       "Bar Marsella",
+		Website:    "https://www.yelp.com/biz/bar-marsella-barcelona",
+		Rating:     4.5,
+		Location:   Location{Latitude: 41.3782, Longitude: 2.1718},
+		PriceLevel: 2,
+		Types:      []string{"bar"},
+		Photos:     []PlacePhoto{{Reference: "yelp:https://s3-media.fl.yelpcdn.com/bphoto/abc/o.jpg"}},
+		OpeningHours: &OpeningHours{Periods: []OpeningPeriod{
+			{Open: DayTime{Day: 1, Time: "2200"}, Close: &DayTime{Day: 2, Time: "0230"}},
+		}},
+		Timezone: "Europe/Madrid",
+	}
+	if !reflect.DeepEqual(expected, details) {
+		t.Errorf("expected %+v, got %+v", expected, details)
+	}
+
+	// 20:30 UTC on a Monday in July is 22:30 in Barcelona.
+	details.markOpening(time.Date(2024, 7, 1, 20, 30, 0, 0, time.UTC))
+	if details.Closed {
+		t.Errorf("expected the bar to be open at 22:30 local time, got %q", details.OpeningNote)
+	}
+}
+
+func TestYelpPriceLevel(t *testing.T) {
+	for price, expected := range map[string]int{"": 0, "$$": 2, "€€€": 3, "££££": 4} {
+		if got := (yelpBusiness{Price: price}).place("").PriceLevel; got != expected {
+			t.Errorf("expected price level %d for %q, got %d", expected, price, got)
+		}
+	}
+}